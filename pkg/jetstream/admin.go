@@ -0,0 +1,503 @@
+package jetstream
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// defaultAPIPrefix is the default prefix of the JetStream API subjects.
+const defaultAPIPrefix = "$JS.API."
+
+// jsErrCodeStreamNotFound is the JetStream API error code returned for missing streams.
+const jsErrCodeStreamNotFound = 10059
+
+// AdminConfig is the configuration to create an admin.
+type AdminConfig struct {
+	// SubjectCalculator is a function used to transform a topic to an array of subjects on creation (defaults to "{topic}.*")
+	// It must be the same as in PublisherConfig and SubscriberConfig.
+	SubjectCalculator SubjectCalculator
+
+	// JetstreamOptions are custom Jetstream options for a connection.
+	JetstreamOptions []nats.JSOpt
+
+	// APIPrefix is the prefix of the JetStream API subjects used for requests not supported by nats.go,
+	// like purging a subject or listing with errors reported (defaults to "$JS.API.").
+	// It must match JetstreamOptions when they set a prefix or domain, for example "$JS.{domain}.API." for nats.Domain.
+	APIPrefix string
+}
+
+func (c *AdminConfig) setDefaults() {
+	if c.SubjectCalculator == nil {
+		c.SubjectCalculator = defaultSubjectCalculator
+	}
+	if c.APIPrefix == "" {
+		c.APIPrefix = defaultAPIPrefix
+	}
+}
+
+// TopicInfo describes the stream storing a watermill topic.
+type TopicInfo struct {
+	// Topic is the watermill topic, which is also the name of the stream.
+	Topic string `json:"topic"`
+
+	// Subjects are the subjects stored in the stream.
+	Subjects []string `json:"subjects"`
+
+	// Messages is the number of messages in the stream.
+	Messages uint64 `json:"messages"`
+
+	// Bytes is the size of messages in the stream.
+	Bytes uint64 `json:"bytes"`
+
+	// FirstSequence is the sequence of the first message in the stream.
+	FirstSequence uint64 `json:"first_sequence"`
+
+	// LastSequence is the sequence of the last message in the stream.
+	LastSequence uint64 `json:"last_sequence"`
+
+	// Consumers is the number of consumers of the stream.
+	Consumers int `json:"consumers"`
+
+	// Created is the time when the stream was created.
+	Created time.Time `json:"created"`
+}
+
+func newTopicInfo(info *nats.StreamInfo) TopicInfo {
+	return TopicInfo{
+		Topic:         info.Config.Name,
+		Subjects:      info.Config.Subjects,
+		Messages:      info.State.Msgs,
+		Bytes:         info.State.Bytes,
+		FirstSequence: info.State.FirstSeq,
+		LastSequence:  info.State.LastSeq,
+		Consumers:     info.State.Consumers,
+		Created:       info.Created,
+	}
+}
+
+// ConsumerInfo describes a consumer of a watermill topic.
+type ConsumerInfo struct {
+	// Topic is the watermill topic the consumer reads.
+	Topic string `json:"topic"`
+
+	// Name is the name of the consumer.
+	Name string `json:"name"`
+
+	// Durable is true when the consumer is durable.
+	Durable bool `json:"durable"`
+
+	// FilterSubject is the subject the consumer is limited to, empty when it reads all subjects of the topic.
+	FilterSubject string `json:"filter_subject,omitempty"`
+
+	// Lag is the number of messages not processed yet, delivered or not.
+	Lag uint64 `json:"lag"`
+
+	// NumPending is the number of messages in the stream not yet delivered to the consumer.
+	NumPending uint64 `json:"num_pending"`
+
+	// NumAckPending is the number of messages delivered, but not yet acknowledged.
+	NumAckPending int `json:"num_ack_pending"`
+
+	// NumRedelivered is the number of messages which were redelivered.
+	NumRedelivered int `json:"num_redelivered"`
+
+	// Subscribed is true when the consumer has an active push subscription or pending pull requests.
+	Subscribed bool `json:"subscribed"`
+
+	// LastDelivered is the time of the last delivery, nil when nothing was delivered.
+	LastDelivered *time.Time `json:"last_delivered,omitempty"`
+}
+
+func newConsumerInfo(info *nats.ConsumerInfo) ConsumerInfo {
+	return ConsumerInfo{
+		Topic:          info.Stream,
+		Name:           info.Name,
+		Durable:        info.Config.Durable != "",
+		FilterSubject:  info.Config.FilterSubject,
+		Lag:            info.NumPending + uint64(info.NumAckPending),
+		NumPending:     info.NumPending,
+		NumAckPending:  info.NumAckPending,
+		NumRedelivered: info.NumRedelivered,
+		Subscribed:     info.PushBound || info.NumWaiting > 0,
+		LastDelivered:  info.Delivered.Last,
+	}
+}
+
+// Admin manages the streams and consumers of watermill topics.
+//
+// Streams are named after topics and store the subjects calculated by SubjectCalculator,
+// the same way as streams provisioned by Publisher and Subscriber.
+type Admin struct {
+	conn             *nats.Conn
+	js               nats.JetStreamContext
+	config           AdminConfig
+	topicInterpreter *topicInterpreter
+}
+
+// NewAdmin creates a new Admin with the provided nats connection.
+func NewAdmin(conn *nats.Conn, config AdminConfig) (*Admin, error) {
+	config.setDefaults()
+
+	js, err := conn.JetStream(config.JetstreamOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Admin{
+		conn:             conn,
+		js:               js,
+		config:           config,
+		topicInterpreter: newTopicInterpreter(js, config.SubjectCalculator),
+	}, nil
+}
+
+// NewAdminFromPublisher creates a new Admin sharing the connection of publisher.
+// SubjectCalculator and JetstreamOptions of config default to the ones of publisher,
+// APIPrefix must be set when the JetstreamOptions set a prefix or domain.
+func NewAdminFromPublisher(publisher *Publisher, config AdminConfig) (*Admin, error) {
+	if config.SubjectCalculator == nil {
+		config.SubjectCalculator = publisher.config.SubjectCalculator
+	}
+	if config.JetstreamOptions == nil {
+		config.JetstreamOptions = publisher.config.JetstreamOptions
+	}
+
+	return NewAdmin(publisher.conn, config)
+}
+
+// ListTopics returns the streams of topics.
+// Streams of key-value and object stores and scheduling streams are not topics, they are skipped.
+func (a *Admin) ListTopics(ctx context.Context) ([]TopicInfo, error) {
+	streams, err := a.listStreams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make([]TopicInfo, 0, len(streams))
+	for _, info := range streams {
+		if isTopicStream(info) {
+			topics = append(topics, newTopicInfo(info))
+		}
+	}
+
+	return topics, nil
+}
+
+// isTopicStream returns false for streams which are not created for topics.
+func isTopicStream(info *nats.StreamInfo) bool {
+	if info.Config.Description == schedulingStreamDescription {
+		return false
+	}
+
+	// nats.go names the streams of key-value and object stores after their buckets
+	for prefix, subjectPrefix := range map[string]string{"KV_": "$KV.", "OBJ_": "$O."} {
+		if strings.HasPrefix(info.Config.Name, prefix) &&
+			len(info.Config.Subjects) > 0 && strings.HasPrefix(info.Config.Subjects[0], subjectPrefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// listStreams returns all streams.
+func (a *Admin) listStreams(ctx context.Context) ([]*nats.StreamInfo, error) {
+	var streams []*nats.StreamInfo
+
+	// nats.go stops listing silently on errors, pages are requested directly to report them
+	for {
+		var resp streamListResponse
+		if err := a.request(ctx, "STREAM.LIST", apiPageRequest{Offset: len(streams)}, &resp); err != nil {
+			return nil, errors.Wrap(err, "cannot list streams")
+		}
+
+		streams = append(streams, resp.Streams...)
+
+		if len(resp.Streams) == 0 || len(streams) >= resp.Total {
+			return streams, nil
+		}
+	}
+}
+
+// Topic returns the stream of topic, nats.ErrStreamNotFound is returned when it does not exist.
+func (a *Admin) Topic(ctx context.Context, topic string) (TopicInfo, error) {
+	info, err := a.js.StreamInfo(topic, nats.Context(ctx))
+	if err != nil {
+		return TopicInfo{}, errors.Wrapf(err, "cannot get stream of topic %s", topic)
+	}
+
+	return newTopicInfo(info), nil
+}
+
+// CreateTopic creates the stream of topic.
+// configure can change the default stream configuration, it may be nil.
+func (a *Admin) CreateTopic(
+	ctx context.Context,
+	topic string,
+	configure func(config *nats.StreamConfig),
+) (TopicInfo, error) {
+	config := a.topicInterpreter.streamConfig(topic)
+	if configure != nil {
+		configure(config)
+	}
+
+	info, err := a.js.AddStream(config, nats.Context(ctx))
+	if err != nil {
+		return TopicInfo{}, errors.Wrapf(err, "cannot create stream of topic %s", topic)
+	}
+
+	return newTopicInfo(info), nil
+}
+
+// UpdateTopic updates the stream of topic, configure changes its current configuration.
+func (a *Admin) UpdateTopic(
+	ctx context.Context,
+	topic string,
+	configure func(config *nats.StreamConfig),
+) (TopicInfo, error) {
+	current, err := a.js.StreamInfo(topic, nats.Context(ctx))
+	if err != nil {
+		return TopicInfo{}, errors.Wrapf(err, "cannot get stream of topic %s", topic)
+	}
+
+	config := current.Config
+	if configure != nil {
+		configure(&config)
+	}
+
+	info, err := a.js.UpdateStream(&config, nats.Context(ctx))
+	if err != nil {
+		return TopicInfo{}, errors.Wrapf(err, "cannot update stream of topic %s", topic)
+	}
+
+	return newTopicInfo(info), nil
+}
+
+// PurgeTopic removes messages of topic, only messages stored under subject are removed when it is not empty.
+func (a *Admin) PurgeTopic(ctx context.Context, topic string, subject string) error {
+	if subject == "" {
+		if err := a.js.PurgeStream(topic, nats.Context(ctx)); err != nil {
+			return errors.Wrapf(err, "cannot purge stream of topic %s", topic)
+		}
+		return nil
+	}
+
+	// nats.go supports purging by subject only for key-value stores
+	if err := a.purgeSubject(ctx, topic, subject); err != nil {
+		return errors.Wrapf(err, "cannot purge subject %s of topic %s", subject, topic)
+	}
+
+	return nil
+}
+
+type streamPurgeRequest struct {
+	Subject string `json:"filter,omitempty"`
+}
+
+type streamPurgeResponse struct {
+	apiResponse
+	Success bool `json:"success"`
+}
+
+func (a *Admin) purgeSubject(ctx context.Context, stream, subject string) error {
+	var resp streamPurgeResponse
+	if err := a.request(ctx, "STREAM.PURGE."+stream, streamPurgeRequest{Subject: subject}, &resp); err != nil {
+		return err
+	}
+
+	if !resp.Success {
+		return errors.New("purge not successful")
+	}
+
+	return nil
+}
+
+type apiError struct {
+	Code        int    `json:"code"`
+	ErrorCode   int    `json:"err_code"`
+	Description string `json:"description"`
+}
+
+type apiResponse struct {
+	Error *apiError `json:"error,omitempty"`
+}
+
+func (r apiResponse) apiError() *apiError {
+	return r.Error
+}
+
+type apiPageRequest struct {
+	Offset int `json:"offset"`
+}
+
+type apiPageResponse struct {
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+type streamListResponse struct {
+	apiResponse
+	apiPageResponse
+	Streams []*nats.StreamInfo `json:"streams"`
+}
+
+type consumerListResponse struct {
+	apiResponse
+	apiPageResponse
+	Consumers []*nats.ConsumerInfo `json:"consumers"`
+}
+
+// request sends req to the JetStream API subject and decodes the response into resp,
+// errors reported by the API are returned.
+func (a *Admin) request(
+	ctx context.Context,
+	subject string,
+	req interface{},
+	resp interface{ apiError() *apiError },
+) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+	}
+
+	msg, err := a.conn.RequestWithContext(ctx, a.config.APIPrefix+subject, data)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(msg.Data, resp); err != nil {
+		return err
+	}
+
+	if apiErr := resp.apiError(); apiErr != nil {
+		if apiErr.ErrorCode == jsErrCodeStreamNotFound {
+			return nats.ErrStreamNotFound
+		}
+		return errors.New(apiErr.Description)
+	}
+
+	return nil
+}
+
+// DeleteTopic deletes the stream of topic with all its messages and consumers.
+func (a *Admin) DeleteTopic(ctx context.Context, topic string) error {
+	if err := a.js.DeleteStream(topic, nats.Context(ctx)); err != nil {
+		return errors.Wrapf(err, "cannot delete stream of topic %s", topic)
+	}
+
+	return nil
+}
+
+// ListConsumers returns the consumers of topic.
+func (a *Admin) ListConsumers(ctx context.Context, topic string) ([]ConsumerInfo, error) {
+	infos, err := a.listConsumers(ctx, topic)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot list consumers of topic %s", topic)
+	}
+
+	consumers := make([]ConsumerInfo, 0, len(infos))
+	for _, info := range infos {
+		consumers = append(consumers, newConsumerInfo(info))
+	}
+
+	return consumers, nil
+}
+
+// listConsumers returns all consumers of stream.
+func (a *Admin) listConsumers(ctx context.Context, stream string) ([]*nats.ConsumerInfo, error) {
+	var consumers []*nats.ConsumerInfo
+
+	// nats.go stops listing silently on errors, pages are requested directly to report them
+	for {
+		var resp consumerListResponse
+		if err := a.request(ctx, "CONSUMER.LIST."+stream, apiPageRequest{Offset: len(consumers)}, &resp); err != nil {
+			return nil, err
+		}
+
+		consumers = append(consumers, resp.Consumers...)
+
+		if len(resp.Consumers) == 0 || len(consumers) >= resp.Total {
+			return consumers, nil
+		}
+	}
+}
+
+// Consumer returns the consumer of topic with the given name,
+// nats.ErrConsumerNotFound is returned when it does not exist.
+func (a *Admin) Consumer(ctx context.Context, topic, name string) (ConsumerInfo, error) {
+	info, err := a.js.ConsumerInfo(topic, name, nats.Context(ctx))
+	if err != nil {
+		return ConsumerInfo{}, errors.Wrapf(err, "cannot get consumer %s of topic %s", name, topic)
+	}
+
+	return newConsumerInfo(info), nil
+}
+
+// CreateConsumer creates a durable pull consumer of topic with explicit acks, reading the primary subject of topic.
+// configure can change the default consumer configuration, for example to create a push consumer. It may be nil.
+func (a *Admin) CreateConsumer(
+	ctx context.Context,
+	topic, durableName string,
+	configure func(config *nats.ConsumerConfig),
+) (ConsumerInfo, error) {
+	config := &nats.ConsumerConfig{
+		Durable:       durableName,
+		FilterSubject: a.config.SubjectCalculator(topic).Primary,
+		AckPolicy:     nats.AckExplicitPolicy,
+	}
+	if configure != nil {
+		configure(config)
+	}
+
+	info, err := a.js.AddConsumer(topic, config, nats.Context(ctx))
+	if err != nil {
+		return ConsumerInfo{}, errors.Wrapf(err, "cannot create consumer %s of topic %s", durableName, topic)
+	}
+
+	return newConsumerInfo(info), nil
+}
+
+// UpdateConsumer updates the durable consumer of topic, configure changes its current configuration.
+func (a *Admin) UpdateConsumer(
+	ctx context.Context,
+	topic, durableName string,
+	configure func(config *nats.ConsumerConfig),
+) (ConsumerInfo, error) {
+	current, err := a.js.ConsumerInfo(topic, durableName, nats.Context(ctx))
+	if err != nil {
+		return ConsumerInfo{}, errors.Wrapf(err, "cannot get consumer %s of topic %s", durableName, topic)
+	}
+
+	config := current.Config
+	if configure != nil {
+		configure(&config)
+	}
+
+	info, err := a.js.UpdateConsumer(topic, &config, nats.Context(ctx))
+	if err != nil {
+		return ConsumerInfo{}, errors.Wrapf(err, "cannot update consumer %s of topic %s", durableName, topic)
+	}
+
+	return newConsumerInfo(info), nil
+}
+
+// DeleteConsumer deletes the consumer of topic with the given name.
+func (a *Admin) DeleteConsumer(ctx context.Context, topic, name string) error {
+	if err := a.js.DeleteConsumer(topic, name, nats.Context(ctx)); err != nil {
+		return errors.Wrapf(err, "cannot delete consumer %s of topic %s", name, topic)
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package jetstream_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin(t *testing.T) {
+	conn, js := natsTestConn(t)
+
+	admin, err := jetstream.NewAdmin(conn, jetstream.AdminConfig{
+		SubjectCalculator: func(topic string) *jetstream.Subjects {
+			return &jetstream.Subjects{
+				Primary:    topic,
+				Additional: []string{topic + ".audit"},
+			}
+		},
+	})
+	require.NoError(t, err)
+
+	topic := "admin_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	created, err := admin.CreateTopic(ctx, topic, func(config *nats.StreamConfig) {
+		config.MaxMsgs = 100
+	})
+	require.NoError(t, err)
+	assert.Equal(t, topic, created.Topic)
+	assert.Equal(t, []string{topic, topic + ".audit"}, created.Subjects)
+
+	for i := 0; i < 3; i++ {
+		_, err := js.Publish(topic, []byte("event"))
+		require.NoError(t, err)
+	}
+	_, err = js.Publish(topic+".audit", []byte("audit"))
+	require.NoError(t, err)
+
+	topics, err := admin.ListTopics(ctx)
+	require.NoError(t, err)
+	var listed []string
+	for _, info := range topics {
+		listed = append(listed, info.Topic)
+	}
+	assert.Contains(t, listed, topic)
+
+	updated, err := admin.UpdateTopic(ctx, topic, func(config *nats.StreamConfig) {
+		config.MaxAge = time.Hour
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, updated.Messages)
+
+	stream, err := js.StreamInfo(topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, stream.Config.MaxMsgs, "update should keep the current configuration")
+	assert.Equal(t, time.Hour, stream.Config.MaxAge)
+
+	consumer, err := admin.CreateConsumer(ctx, topic, "ops", nil)
+	require.NoError(t, err)
+	assert.Equal(t, topic, consumer.Topic)
+	assert.Equal(t, topic, consumer.FilterSubject)
+	assert.True(t, consumer.Durable)
+	assert.EqualValues(t, 3, consumer.Lag)
+
+	consumer, err = admin.UpdateConsumer(ctx, topic, "ops", func(config *nats.ConsumerConfig) {
+		config.MaxAckPending = 10
+	})
+	require.NoError(t, err)
+
+	info, err := js.ConsumerInfo(topic, "ops")
+	require.NoError(t, err)
+	assert.Equal(t, 10, info.Config.MaxAckPending)
+
+	consumers, err := admin.ListConsumers(ctx, topic)
+	require.NoError(t, err)
+	require.Len(t, consumers, 1)
+	assert.Equal(t, consumer, consumers[0])
+
+	require.NoError(t, admin.PurgeTopic(ctx, topic, topic+".audit"))
+
+	purged, err := admin.Topic(ctx, topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, purged.Messages, "only messages of the subject should be purged")
+
+	require.NoError(t, admin.PurgeTopic(ctx, topic, ""))
+
+	purged, err = admin.Topic(ctx, topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, purged.Messages)
+
+	require.NoError(t, admin.DeleteConsumer(ctx, topic, "ops"))
+	_, err = admin.Consumer(ctx, topic, "ops")
+	require.ErrorIs(t, err, nats.ErrConsumerNotFound)
+
+	require.NoError(t, admin.DeleteTopic(ctx, topic))
+	_, err = admin.Topic(ctx, topic)
+	require.ErrorIs(t, err, nats.ErrStreamNotFound)
+
+	err = admin.PurgeTopic(ctx, topic, topic+".audit")
+	require.ErrorIs(t, err, nats.ErrStreamNotFound)
+}
+
+func TestAdmin_List_Pages(t *testing.T) {
+	_, js, pub := natsTestSetup(t)
+
+	admin, err := jetstream.NewAdminFromPublisher(pub, jetstream.AdminConfig{})
+	require.NoError(t, err)
+
+	topic := "admin_pages_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err = admin.CreateTopic(ctx, topic, nil)
+	require.NoError(t, err)
+
+	// the server returns up to 256 consumers per page
+	const consumersCount = 300
+	for i := 0; i < consumersCount; i++ {
+		_, err := js.AddConsumer(topic, &nats.ConsumerConfig{
+			Durable:   fmt.Sprintf("consumer_%d", i),
+			AckPolicy: nats.AckExplicitPolicy,
+		})
+		require.NoError(t, err)
+	}
+
+	consumers, err := admin.ListConsumers(ctx, topic)
+	require.NoError(t, err)
+	assert.Len(t, consumers, consumersCount)
+
+	_, err = admin.ListConsumers(ctx, "admin_missing_"+watermill.NewShortUUID())
+	require.ErrorIs(t, err, nats.ErrStreamNotFound)
+
+	cancel()
+	_, err = admin.ListTopics(ctx)
+	require.Error(t, err, "failed requests should not look like a shorter list")
+}
+
+func TestNewAdminFromPublisher(t *testing.T) {
+	_, js, pub := natsTestSetup(t)
+
+	topic := "admin_publisher_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	admin, err := jetstream.NewAdminFromPublisher(pub, jetstream.AdminConfig{})
+	require.NoError(t, err)
+
+	created, err := admin.CreateTopic(context.Background(), topic, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{topic}, created.Subjects)
+
+	// requests not supported by nats.go use APIPrefix, which must match the JetStream options
+	admin, err = jetstream.NewAdminFromPublisher(pub, jetstream.AdminConfig{APIPrefix: "$JS.other.API."})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err = admin.ListTopics(ctx)
+	require.Error(t, err)
+}
+
+func TestAdmin_List_Topics_Skips_Other_Streams(t *testing.T) {
+	_, js, _ := natsTestSetup(t)
+
+	name := "admin_other_" + watermill.NewShortUUID()
+	scheduling := &jetstream.SchedulingConfig{Stream: name + "_scheduling"}
+	defer func() {
+		_ = js.DeleteKeyValue(name)
+		_ = js.DeleteObjectStore(name)
+		_ = js.DeleteStream(scheduling.Stream)
+		_ = js.DeleteStream(name)
+	}()
+
+	_, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: name})
+	require.NoError(t, err)
+	_, err = js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: name})
+	require.NoError(t, err)
+
+	pub := newTestPublisher(t, func(config *jetstream.PublisherConfig) {
+		config.Scheduling = scheduling
+	})
+
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+	jetstream.SetDeliverAt(msg, time.Now().Add(time.Hour))
+	require.NoError(t, pub.Publish(name, msg))
+	require.NoError(t, pub.Publish(name, message.NewMessage(watermill.NewUUID(), nil)))
+
+	_, err = js.StreamInfo(scheduling.Stream)
+	require.NoError(t, err)
+
+	admin, err := jetstream.NewAdminFromPublisher(pub, jetstream.AdminConfig{})
+	require.NoError(t, err)
+
+	topics, err := admin.ListTopics(context.Background())
+	require.NoError(t, err)
+
+	var listed []string
+	for _, info := range topics {
+		listed = append(listed, info.Topic)
+	}
+	assert.Contains(t, listed, name)
+	assert.NotContains(t, listed, "KV_"+name)
+	assert.NotContains(t, listed, "OBJ_"+name)
+	assert.NotContains(t, listed, scheduling.Stream)
+}
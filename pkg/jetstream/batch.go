@@ -0,0 +1,491 @@
+package jetstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// AckBatch acks all messages of a batch received from SubscribeBatch.
+func AckBatch(msgs []*message.Message) {
+	for _, msg := range msgs {
+		msg.Ack()
+	}
+}
+
+// NackBatch nacks all messages of a batch received from SubscribeBatch.
+func NackBatch(msgs []*message.Message) {
+	for _, msg := range msgs {
+		msg.Nack()
+	}
+}
+
+type batchTermKey struct{}
+
+// batchTerm marks a message of a batch to be terminated instead of redelivered when it is nacked.
+type batchTerm struct {
+	lock   sync.Mutex
+	termed bool
+}
+
+func (t *batchTerm) set() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.termed = true
+}
+
+func (t *batchTerm) isSet() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.termed
+}
+
+// TermMessage terminates a message received from SubscribeBatch, so it is not redelivered.
+// It returns false when msg was not received from SubscribeBatch.
+func TermMessage(msg *message.Message) bool {
+	term, ok := msg.Context().Value(batchTermKey{}).(*batchTerm)
+	if !ok {
+		return false
+	}
+
+	term.set()
+	msg.Nack()
+
+	return true
+}
+
+// TermBatch terminates all messages of a batch received from SubscribeBatch.
+func TermBatch(msgs []*message.Message) {
+	for _, msg := range msgs {
+		TermMessage(msg)
+	}
+}
+
+// SubscribeBatch subscribes messages from JetStream with a pull consumer, delivering them in batches
+// of up to size messages, waiting at most maxWait for a batch to fill up.
+//
+// Every message must be acked, nacked or termed (see TermMessage), individually or with AckBatch, NackBatch
+// and TermBatch. The next batch is fetched once all messages of the previous one are handled.
+// AckWaitTimeout applies to the batch as a whole, starting when it is fetched: until then, progress of
+// all pending messages of the batch is signalled to the server, afterwards pending messages are nacked.
+//
+// The pull consumer is created with DurableName (or a generated name when it is empty, which is deleted
+// when the subscription ends, or by CleanupConsumers when the subscriber crashed).
+// SubscribeOptions, Workers and the push consumer settings are not used.
+func (s *Subscriber) SubscribeBatch(
+	ctx context.Context,
+	topic string,
+	size int,
+	maxWait time.Duration,
+) (<-chan []*message.Message, error) {
+	if size < 1 {
+		return nil, errors.New("batch size must be greater than 0")
+	}
+	if maxWait <= 0 {
+		return nil, errors.New("batch max wait must be greater than 0")
+	}
+
+	config, err := s.topicConfig(topic, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid subscription config of topic %s", topic)
+	}
+
+	if config.AutoProvision {
+		if err := newTopicInterpreter(s.js, config.SubjectCalculator).ensureStream(topic); err != nil {
+			return nil, errors.Wrap(err, "cannot initialize subscribe")
+		}
+	}
+
+	// streams are named after topics, as created by topicInterpreter
+	stream := topic
+	subject := config.SubjectCalculator(topic).Primary
+
+	durableName := config.durableName(topic)
+	generated := durableName == ""
+	if generated {
+		durableName = "watermill_batch_" + watermill.NewShortUUID()
+	}
+
+	if err := s.ensurePullConsumer(config, stream, subject, durableName, generated); err != nil {
+		return nil, errors.Wrap(err, "cannot create pull consumer")
+	}
+
+	// binding to the consumer keeps it on unsubscribe
+	sub, err := s.js.PullSubscribe(subject, durableName, nats.Bind(stream, durableName))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot subscribe")
+	}
+
+	output := make(chan []*message.Message)
+
+	logFields := watermill.LogFields{
+		"topic":    topic,
+		"consumer": durableName,
+	}
+
+	s.outputsWg.Add(1)
+	go func() {
+		defer s.outputsWg.Done()
+		defer close(output)
+		defer func() {
+			if err := sub.Unsubscribe(); err != nil {
+				s.logger.Error("Cannot unsubscribe", err, logFields)
+			}
+
+			if generated || config.DeleteDurableConsumers {
+				err := s.js.DeleteConsumer(stream, durableName)
+				if err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+					s.logger.Error("Cannot delete consumer", err, logFields)
+				}
+			}
+		}()
+
+		s.fetchBatches(ctx, config, topic, sub, size, maxWait, output, logFields)
+	}()
+
+	return output, nil
+}
+
+func (s *Subscriber) ensurePullConsumer(
+	config *SubscriberSubscriptionConfig,
+	stream, subject, durableName string,
+	generated bool,
+) error {
+	_, err := s.js.ConsumerInfo(stream, durableName)
+	if !errors.Is(err, nats.ErrConsumerNotFound) {
+		return err
+	}
+
+	consumerConfig := &nats.ConsumerConfig{
+		Durable:           durableName,
+		FilterSubject:     subject,
+		AckPolicy:         nats.AckExplicitPolicy,
+		AckWait:           config.AckWaitTimeout,
+		MaxAckPending:     config.MaxAckPending,
+		InactiveThreshold: config.InactiveThreshold,
+	}
+	if generated {
+		consumerConfig.Description = ConsumerDescription
+	}
+
+	_, err = s.js.AddConsumer(stream, consumerConfig)
+
+	return err
+}
+
+func (s *Subscriber) fetchBatches(
+	ctx context.Context,
+	config *SubscriberSubscriptionConfig,
+	topic string,
+	sub *nats.Subscription,
+	size int,
+	maxWait time.Duration,
+	output chan []*message.Message,
+	logFields watermill.LogFields,
+) {
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, maxWait)
+		natsMsgs, err := sub.Fetch(size, nats.Context(fetchCtx))
+		cancel()
+
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) || ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			s.logger.Error("Cannot fetch messages", err, logFields)
+
+			select {
+			case <-time.After(maxWait):
+			case <-s.closing:
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		s.processBatch(ctx, config, topic, natsMsgs, output, logFields)
+	}
+}
+
+// batchMessage is a message of a batch waiting to be acked.
+type batchMessage struct {
+	natsMsg *nats.Msg
+	msg     *message.Message
+	term    *batchTerm
+	endSpan func(error)
+}
+
+func (s *Subscriber) processBatch(
+	ctx context.Context,
+	config *SubscriberSubscriptionConfig,
+	topic string,
+	natsMsgs []*nats.Msg,
+	output chan []*message.Message,
+	logFields watermill.LogFields,
+) {
+	// the ack wait of the batch starts when it is fetched, waiting for the output to be read is included
+	expired := make(chan struct{})
+	deadline := time.AfterFunc(config.AckWaitTimeout, func() {
+		close(expired)
+	})
+	defer deadline.Stop()
+
+	batchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pending := make(map[*nats.Msg]struct{}, len(natsMsgs))
+	pendingLock := sync.Mutex{}
+
+	// progress is signalled until every message is handled, also while waiting for the output to be read
+	go s.signalBatchProgress(batchCtx, config.AckWaitTimeout, pending, &pendingLock, logFields)
+
+	var batch []batchMessage
+	for _, natsMsg := range natsMsgs {
+		m, ok := s.receiveBatchMessage(batchCtx, config, topic, natsMsg, logFields)
+		if !ok {
+			continue
+		}
+
+		pendingLock.Lock()
+		pending[natsMsg] = struct{}{}
+		pendingLock.Unlock()
+
+		batch = append(batch, m)
+	}
+
+	if len(batch) == 0 {
+		return
+	}
+
+	msgs := make([]*message.Message, len(batch))
+	for i, m := range batch {
+		msgs[i] = m.msg
+	}
+
+	// messages not sent to the output are handled as discarded, or nacked when the batch expired
+	select {
+	case <-s.closing:
+	case <-ctx.Done():
+	case <-expired:
+		s.logger.Trace("Batch ack timeout before it was sent to consumer", logFields)
+	case output <- msgs:
+		s.logger.Trace("Batch sent to consumer", logFields.Add(watermill.LogFields{"batch_size": len(msgs)}))
+	}
+
+	wg := sync.WaitGroup{}
+	for _, m := range batch {
+		wg.Add(1)
+		go func(m batchMessage) {
+			defer wg.Done()
+
+			err := s.handleBatchMessage(ctx, config, topic, m, expired, logFields)
+
+			pendingLock.Lock()
+			delete(pending, m.natsMsg)
+			pendingLock.Unlock()
+
+			if config.Metrics != nil {
+				config.Metrics.InFlightChanged(m.msg.Context(), topic, -1)
+			}
+			m.endSpan(err)
+		}(m)
+	}
+	wg.Wait()
+}
+
+// receiveBatchMessage unmarshals natsMsg, reporting it to the tracer and metrics like processMessage does.
+// It returns false when natsMsg can't be unmarshaled.
+func (s *Subscriber) receiveBatchMessage(
+	ctx context.Context,
+	config *SubscriberSubscriptionConfig,
+	topic string,
+	natsMsg *nats.Msg,
+	logFields watermill.LogFields,
+) (batchMessage, bool) {
+	endSpan := func(error) {}
+	if config.Tracer != nil {
+		ctx, endSpan = config.Tracer.StartConsume(ctx, topic, natsMsg)
+	}
+
+	metrics := config.Metrics
+	if metrics != nil {
+		metrics.MessageReceived(ctx, topic)
+
+		if metadata, err := natsMsg.Metadata(); err == nil {
+			metrics.ConsumerLag(ctx, topic, metadata.NumPending)
+		}
+	}
+
+	msg, err := s.unmarshalBatchMessage(ctx, config, topic, natsMsg, logFields)
+	if err != nil {
+		endSpan(errors.Wrap(err, "cannot unmarshal message"))
+		return batchMessage{}, false
+	}
+
+	if metrics != nil {
+		metrics.InFlightChanged(ctx, topic, 1)
+	}
+
+	term := &batchTerm{}
+	msg.SetContext(context.WithValue(ctx, batchTermKey{}, term))
+
+	return batchMessage{natsMsg: natsMsg, msg: msg, term: term, endSpan: endSpan}, true
+}
+
+func (s *Subscriber) unmarshalBatchMessage(
+	ctx context.Context,
+	config *SubscriberSubscriptionConfig,
+	topic string,
+	natsMsg *nats.Msg,
+	logFields watermill.LogFields,
+) (*message.Message, error) {
+	var msg *message.Message
+
+	resolved, err := s.claimChecks.resolve(natsMsg)
+	if err == nil {
+		msg, err = config.Unmarshaler.Unmarshal(resolved)
+	}
+	if err == nil {
+		return msg, nil
+	}
+
+	s.logger.Error("Cannot unmarshal message", err, logFields)
+	if config.Metrics != nil {
+		config.Metrics.MessageUnmarshalFailed(ctx, topic)
+	}
+
+	if isPermanentError(err) {
+		if err := natsMsg.Term(); err != nil {
+			s.logger.Error("Cannot send term", err, logFields)
+		} else if config.Metrics != nil {
+			config.Metrics.MessageTermed(ctx, topic)
+		}
+	}
+
+	return nil, err
+}
+
+// handleBatchMessage waits until m is handled and sends the ack, nak or term to the server.
+// The returned error is reported to the tracer.
+func (s *Subscriber) handleBatchMessage(
+	ctx context.Context,
+	config *SubscriberSubscriptionConfig,
+	topic string,
+	m batchMessage,
+	expired <-chan struct{},
+	logFields watermill.LogFields,
+) error {
+	messageLogFields := logFields.Add(watermill.LogFields{"message_uuid": m.msg.UUID})
+	metrics := config.Metrics
+	msgCtx := m.msg.Context()
+
+	select {
+	case <-m.msg.Acked():
+		var err error
+		if config.AckSync {
+			err = m.natsMsg.AckSync()
+		} else {
+			err = m.natsMsg.Ack()
+		}
+		if err != nil {
+			s.logger.Error("Cannot send ack", err, messageLogFields)
+			return errors.Wrap(err, "cannot send ack")
+		}
+
+		if config.ClaimCheck != nil && config.ClaimCheck.DeleteOnAck {
+			if err := s.claimChecks.delete(m.natsMsg); err != nil {
+				s.logger.Error("Cannot delete claim-checked message data", err, messageLogFields)
+			}
+		}
+
+		if metrics != nil {
+			metrics.MessageAcked(msgCtx, topic)
+		}
+
+		return nil
+	case <-m.msg.Nacked():
+		if m.term.isSet() {
+			if err := m.natsMsg.Term(); err != nil {
+				s.logger.Error("Cannot send term", err, messageLogFields)
+			} else if metrics != nil {
+				metrics.MessageTermed(msgCtx, topic)
+			}
+
+			return ErrMessageTermed
+		}
+
+		if err := m.natsMsg.Nak(); err != nil {
+			s.logger.Error("Cannot send nak", err, messageLogFields)
+		} else if metrics != nil {
+			metrics.MessageNacked(msgCtx, topic, 0)
+		}
+
+		return ErrMessageNacked
+	case <-expired:
+		s.logger.Trace("Batch ack timeout, message nacked", messageLogFields)
+		if err := m.natsMsg.Nak(); err != nil {
+			s.logger.Error("Cannot send nak", err, messageLogFields)
+		}
+
+		if metrics != nil {
+			metrics.MessageAckTimedOut(msgCtx, topic)
+		}
+
+		return ErrAckTimeout
+	case <-s.closing:
+		s.logger.Trace("Closing, message discarded before ack", messageLogFields)
+		return ErrMessageDiscarded
+	case <-ctx.Done():
+		s.logger.Trace("Context cancelled, message discarded before ack", messageLogFields)
+		return ErrMessageDiscarded
+	}
+}
+
+func (s *Subscriber) signalBatchProgress(
+	ctx context.Context,
+	ackWait time.Duration,
+	pending map[*nats.Msg]struct{},
+	pendingLock *sync.Mutex,
+	logFields watermill.LogFields,
+) {
+	// the interval must be positive, also for an ack wait of 1ns
+	interval := ackWait / 2
+	if interval <= 0 {
+		interval = ackWait
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.closing:
+			return
+		case <-ticker.C:
+		}
+
+		pendingLock.Lock()
+		for natsMsg := range pending {
+			if err := natsMsg.InProgress(); err != nil {
+				s.logger.Error("Cannot signal message progress", err, logFields)
+			}
+		}
+		pendingLock.Unlock()
+	}
+}
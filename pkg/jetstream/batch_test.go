@@ -0,0 +1,166 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriber_SubscribeBatch(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	topic := "batch_" + watermill.NewShortUUID()
+	durableName := "durable_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	metrics := newCountingMetrics()
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:            natsURL,
+		Unmarshaler:    &jetstream.NATSMarshaler{},
+		AutoProvision:  true,
+		DurableName:    durableName,
+		AckWaitTimeout: 2 * time.Second,
+		Metrics:        metrics,
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	var published []*message.Message
+	for i := 0; i < 7; i++ {
+		msg := message.NewMessage(watermill.NewUUID(), nil)
+		published = append(published, msg)
+	}
+	require.NoError(t, pub.Publish(topic, published...))
+
+	termed, nacked := published[3].UUID, published[4].UUID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	batches, err := sub.SubscribeBatch(ctx, topic, 5, 500*time.Millisecond)
+	require.NoError(t, err)
+
+	received := map[string]int{}
+	acked := map[string]struct{}{}
+
+	// batch sizes depend on timing, only the messages received across batches are checked
+	for len(acked) < len(published)-1 {
+		var batch []*message.Message
+		select {
+		case batch = <-batches:
+		case <-ctx.Done():
+			t.Fatalf("only %d messages acked", len(acked))
+		}
+
+		require.LessOrEqual(t, len(batch), 5)
+
+		// processing takes longer than half of AckWaitTimeout, progress is signalled so nothing is redelivered
+		time.Sleep(1200 * time.Millisecond)
+
+		for _, msg := range batch {
+			received[msg.UUID]++
+
+			switch {
+			case msg.UUID == termed:
+				require.True(t, jetstream.TermMessage(msg))
+			case msg.UUID == nacked && received[msg.UUID] == 1:
+				msg.Nack()
+			default:
+				msg.Ack()
+				acked[msg.UUID] = struct{}{}
+			}
+		}
+	}
+
+	select {
+	case batch := <-batches:
+		t.Fatalf("unexpected batch of %d messages", len(batch))
+	case <-time.After(time.Second):
+		// all messages are handled
+	}
+
+	for _, msg := range published {
+		expected := 1
+		if msg.UUID == nacked {
+			expected = 2
+		}
+		assert.Equal(t, expected, received[msg.UUID], "message %s", msg.UUID)
+	}
+
+	info, err := js.ConsumerInfo(topic, durableName)
+	require.NoError(t, err)
+	assert.Equal(t, 0, info.NumAckPending)
+
+	assert.Eventually(t, func() bool {
+		return metrics.count("in_flight") == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 8, metrics.count("received"))
+	assert.Equal(t, 6, metrics.count("acked"))
+	assert.Equal(t, 1, metrics.count("nacked"))
+	assert.Equal(t, 1, metrics.count("termed"))
+	assert.Equal(t, 0, metrics.count("ack_timed_out"))
+
+	assert.False(t, jetstream.TermMessage(message.NewMessage(watermill.NewUUID(), nil)))
+}
+
+func TestSubscriber_SubscribeBatch_Ack_Timeout(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	topic := "batch_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:            natsURL,
+		Unmarshaler:    &jetstream.NATSMarshaler{},
+		AutoProvision:  true,
+		AckWaitTimeout: 500 * time.Millisecond,
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	published := map[string]struct{}{}
+	for i := 0; i < 3; i++ {
+		msg := message.NewMessage(watermill.NewUUID(), nil)
+		published[msg.UUID] = struct{}{}
+		require.NoError(t, pub.Publish(topic, msg))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	batches, err := sub.SubscribeBatch(ctx, topic, 3, 500*time.Millisecond)
+	require.NoError(t, err)
+
+	receiveAll := func(handle func(msg *message.Message)) {
+		received := map[string]struct{}{}
+		for len(received) < len(published) {
+			select {
+			case batch := <-batches:
+				for _, msg := range batch {
+					received[msg.UUID] = struct{}{}
+					handle(msg)
+				}
+			case <-ctx.Done():
+				t.Fatalf("only %d messages received", len(received))
+			}
+		}
+		assert.Equal(t, published, received)
+	}
+
+	// all messages are nacked once the ack wait of the batch expires
+	receiveAll(func(msg *message.Message) {})
+	receiveAll(func(msg *message.Message) {
+		msg.Ack()
+	})
+}
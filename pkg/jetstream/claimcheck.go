@@ -0,0 +1,195 @@
+package jetstream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ClaimCheckBucketHdr is the header storing the Object Store bucket of a claim-checked message data.
+	ClaimCheckBucketHdr = "_watermill_claim_check_bucket"
+
+	// ClaimCheckObjectHdr is the header storing the object name of a claim-checked message data.
+	ClaimCheckObjectHdr = "_watermill_claim_check_object"
+)
+
+// ClaimCheckConfig configures storing large messages in a JetStream Object Store bucket.
+//
+// The Publisher stores the data of messages above the threshold in the bucket and publishes a message
+// carrying only a reference in the ClaimCheckBucketHdr and ClaimCheckObjectHdr headers (other headers are kept).
+// The Subscriber fetches the data before unmarshaling, regardless of its configuration.
+type ClaimCheckConfig struct {
+	// Bucket is the name of the Object Store bucket. It is created by the Publisher when missing
+	// and AutoProvision is enabled.
+	Bucket string
+
+	// Threshold is the minimal size of the marshaled data in bytes to store in the bucket.
+	// When 0, only messages which would exceed the max payload of the server are stored.
+	Threshold int
+
+	// TTL is the time after which objects expire, used when the bucket is created. Objects never expire when 0.
+	TTL time.Duration
+
+	// DeleteOnAck makes the Subscriber delete the object once the message is acked.
+	// It requires AckSync in the SubscriberConfig, so the object is deleted only after the server confirmed the ack,
+	// a lost ack would redeliver a message without its data.
+	// It must not be enabled when a topic has more than one consumer (for example several durable names),
+	// as the first one acking the message would remove the data for the others; use TTL instead.
+	DeleteOnAck bool
+}
+
+// claimCheckError is returned when the data of a claim-checked message doesn't exist anymore.
+type claimCheckError struct {
+	err error
+}
+
+func (e *claimCheckError) Error() string {
+	return "claim-checked message data is missing: " + e.err.Error()
+}
+
+func (e *claimCheckError) Unwrap() error {
+	return e.err
+}
+
+// Permanent returns true, the object will not reappear.
+func (e *claimCheckError) Permanent() bool {
+	return true
+}
+
+// claimChecks stores and fetches claim-checked message data.
+type claimChecks struct {
+	js nats.JetStreamContext
+
+	lock    sync.Mutex
+	buckets map[string]nats.ObjectStore
+}
+
+func newClaimChecks(js nats.JetStreamContext) *claimChecks {
+	return &claimChecks{
+		js:      js,
+		buckets: make(map[string]nats.ObjectStore),
+	}
+}
+
+// store moves natsMsg data to the bucket if it is above the threshold, it returns true when the data was moved.
+// The bucket is created when missing and autoProvision is true.
+func (c *claimChecks) store(config ClaimCheckConfig, natsMsg *nats.Msg, maxPayload int64, autoProvision bool) (bool, error) {
+	if config.Threshold > 0 {
+		if len(natsMsg.Data) < config.Threshold {
+			return false, nil
+		}
+	} else if msgSize(natsMsg)+claimCheckHeadersReserve <= maxPayload {
+		return false, nil
+	}
+
+	obs, err := c.bucket(config, autoProvision)
+	if err != nil {
+		return false, err
+	}
+
+	name := watermill.NewUUID()
+
+	if _, err := obs.PutBytes(name, natsMsg.Data); err != nil {
+		return false, errors.Wrapf(err, "cannot store message data in bucket %s", config.Bucket)
+	}
+
+	if natsMsg.Header == nil {
+		natsMsg.Header = make(nats.Header)
+	}
+
+	natsMsg.Header.Set(ClaimCheckBucketHdr, config.Bucket)
+	natsMsg.Header.Set(ClaimCheckObjectHdr, name)
+	natsMsg.Data = nil
+
+	return true, nil
+}
+
+// resolve returns natsMsg with the data fetched from the bucket, or natsMsg when it is not claim-checked.
+func (c *claimChecks) resolve(natsMsg *nats.Msg) (*nats.Msg, error) {
+	bucket, name := natsMsg.Header.Get(ClaimCheckBucketHdr), natsMsg.Header.Get(ClaimCheckObjectHdr)
+	if bucket == "" || name == "" {
+		return natsMsg, nil
+	}
+
+	obs, err := c.bucket(ClaimCheckConfig{Bucket: bucket}, false)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		return nil, &claimCheckError{err}
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, err := obs.GetBytes(name)
+	if errors.Is(err, nats.ErrObjectNotFound) {
+		return nil, &claimCheckError{err}
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "cannot get message data from bucket %s", bucket)
+	}
+
+	return withData(natsMsg, data, ClaimCheckBucketHdr, ClaimCheckObjectHdr), nil
+}
+
+// delete deletes the claim-checked data of natsMsg.
+func (c *claimChecks) delete(natsMsg *nats.Msg) error {
+	bucket, name := natsMsg.Header.Get(ClaimCheckBucketHdr), natsMsg.Header.Get(ClaimCheckObjectHdr)
+	if bucket == "" || name == "" {
+		return nil
+	}
+
+	obs, err := c.bucket(ClaimCheckConfig{Bucket: bucket}, false)
+	if err != nil {
+		return err
+	}
+
+	if err := obs.Delete(name); err != nil && !errors.Is(err, nats.ErrObjectNotFound) {
+		return errors.Wrapf(err, "cannot delete message data from bucket %s", bucket)
+	}
+
+	return nil
+}
+
+func (c *claimChecks) bucket(config ClaimCheckConfig, create bool) (nats.ObjectStore, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if obs, ok := c.buckets[config.Bucket]; ok {
+		return obs, nil
+	}
+
+	obs, err := c.js.ObjectStore(config.Bucket)
+	if errors.Is(err, nats.ErrStreamNotFound) && create {
+		obs, err = c.js.CreateObjectStore(&nats.ObjectStoreConfig{
+			Bucket: config.Bucket,
+			TTL:    config.TTL,
+		})
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get object store %s", config.Bucket)
+	}
+
+	c.buckets[config.Bucket] = obs
+
+	return obs, nil
+}
+
+// claimCheckHeadersReserve is reserved for headers added by PublisherConfig.PublishOptions
+// (expected stream and sequences) after the size of a message is checked.
+const claimCheckHeadersReserve = 256
+
+// msgSize returns the approximate size of natsMsg in the NATS protocol, including headers.
+func msgSize(natsMsg *nats.Msg) int64 {
+	// NATS/1.0 status line and trailing CRLF
+	size := len(natsMsg.Data) + 12
+
+	for k, values := range natsMsg.Header {
+		for _, v := range values {
+			// "key: value\r\n"
+			size += len(k) + len(v) + 4
+		}
+	}
+
+	return int64(size)
+}
@@ -0,0 +1,118 @@
+package jetstream_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimCheck(t *testing.T) {
+	conn, js := natsTestConn(t)
+
+	topic := "claim_check_" + watermill.NewShortUUID()
+	claimCheck := &jetstream.ClaimCheckConfig{
+		Bucket:      topic,
+		DeleteOnAck: true,
+	}
+	defer func() {
+		_ = js.DeleteObjectStore(claimCheck.Bucket)
+		_ = js.DeleteStream(topic)
+	}()
+
+	pub := newTestPublisher(t, func(config *jetstream.PublisherConfig) {
+		config.ClaimCheck = claimCheck
+	})
+
+	smallMsg := message.NewMessage(watermill.NewUUID(), []byte("small"))
+	largeMsg := message.NewMessage(watermill.NewUUID(), bytes.Repeat([]byte("large"), int(conn.MaxPayload())))
+	largeMsg.Metadata.Set("foo", "bar")
+
+	require.NoError(t, pub.Publish(topic, smallMsg, largeMsg))
+
+	obs, err := js.ObjectStore(claimCheck.Bucket)
+	require.NoError(t, err)
+	assert.Len(t, storedObjects(t, obs), 1, "only the large message should be claim-checked")
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:            natsTestURL(),
+		Unmarshaler:    &jetstream.NATSMarshaler{},
+		AutoProvision:  true,
+		AckWaitTimeout: 10 * time.Second,
+		AckSync:        true,
+		ClaimCheck:     claimCheck,
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	for _, expected := range []*message.Message{smallMsg, largeMsg} {
+		select {
+		case received := <-messages:
+			assert.True(t, expected.Equals(received))
+			received.Ack()
+		case <-ctx.Done():
+			t.Fatal("message not received")
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(storedObjects(t, obs)) == 0
+	}, 10*time.Second, 100*time.Millisecond, "claim-checked data should be deleted on ack")
+}
+
+func TestClaimCheck_Near_Max_Payload(t *testing.T) {
+	conn, js := natsTestConn(t)
+
+	topic := "claim_check_near_max_" + watermill.NewShortUUID()
+	claimCheck := &jetstream.ClaimCheckConfig{Bucket: topic}
+	defer func() {
+		_ = js.DeleteObjectStore(claimCheck.Bucket)
+		_ = js.DeleteStream(topic)
+	}()
+
+	pub := newTestPublisher(t, func(config *jetstream.PublisherConfig) {
+		config.TrackMsgId = true
+		config.ClaimCheck = claimCheck
+	})
+
+	// fits the max payload only without the message ID header added when publishing
+	uuid := watermill.NewUUID()
+	uuidHeaderSize := len(jetstream.WatermillUUIDHdr) + len(uuid) + 4
+	msg := message.NewMessage(uuid, bytes.Repeat([]byte("a"), int(conn.MaxPayload())-12-uuidHeaderSize))
+
+	require.NoError(t, pub.Publish(topic, msg))
+
+	obs, err := js.ObjectStore(claimCheck.Bucket)
+	require.NoError(t, err)
+	assert.Len(t, storedObjects(t, obs), 1)
+}
+
+func storedObjects(t *testing.T, obs nats.ObjectStore) []*nats.ObjectInfo {
+	objects, err := obs.List()
+	if err == nats.ErrNoObjectsFound {
+		return nil
+	}
+	require.NoError(t, err)
+
+	var stored []*nats.ObjectInfo
+	for _, object := range objects {
+		if !object.Deleted {
+			stored = append(stored, object)
+		}
+	}
+
+	return stored
+}
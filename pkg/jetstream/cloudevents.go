@@ -0,0 +1,306 @@
+package jetstream
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"strings"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// CloudEventsSpecVersion is the CloudEvents specification version produced by CloudEventsMarshaler.
+	CloudEventsSpecVersion = "1.0"
+
+	// CloudEventsContentType is the content type of structured mode CloudEvents.
+	CloudEventsContentType = "application/cloudevents+json"
+
+	// CloudEventsHeaderPrefix is the prefix of binary mode CloudEvents attribute headers.
+	CloudEventsHeaderPrefix = "ce-"
+
+	// ContentTypeHdr is the header carrying the content type of the message data.
+	ContentTypeHdr = "content-type"
+)
+
+// CloudEventsMode is the CloudEvents content mode.
+type CloudEventsMode int
+
+const (
+	// CloudEventsBinaryMode stores attributes in ce-* headers and the payload as message data.
+	CloudEventsBinaryMode CloudEventsMode = iota
+
+	// CloudEventsStructuredMode stores the whole event as application/cloudevents+json message data.
+	CloudEventsStructuredMode
+)
+
+var (
+	// cloudEventsIDAttributes are not mapped to metadata, id is the watermill UUID.
+	cloudEventsIDAttributes = []string{"id", "specversion"}
+
+	// cloudEventsDataAttributes are used in structured mode to carry the payload.
+	cloudEventsDataAttributes = []string{"data", "data_base64"}
+)
+
+// ErrCloudEventIDMissing is returned (wrapped in InvalidCloudEventError) when an event has no id attribute.
+var ErrCloudEventIDMissing = errors.New("CloudEvents attribute \"id\" is missing")
+
+// InvalidCloudEventError is returned by CloudEventsMarshaler.Unmarshal for events missing required attributes.
+//
+// It is a PermanentError, so Subscriber terminates such messages instead of letting them be redelivered.
+type InvalidCloudEventError struct {
+	Err error
+}
+
+func (e *InvalidCloudEventError) Error() string {
+	return "invalid CloudEvent: " + e.Err.Error()
+}
+
+func (e *InvalidCloudEventError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent returns true, redelivery will not add the attributes.
+func (e *InvalidCloudEventError) Permanent() bool {
+	return true
+}
+
+// CloudEventsMarshaler marshals watermill messages to CloudEvents, using the NATS protocol binding.
+//
+// The watermill UUID is mapped to the id attribute and the payload to the event data.
+// Metadata is mapped to attributes with the same name: context attributes (source, type, subject, time,
+// dataschema, datacontenttype) when the metadata key is one of them and extension attributes otherwise.
+// Keys are not transformed, to interoperate with non-watermill services metadata keys should be valid
+// attribute names (lower-case letters and digits).
+//
+// In structured mode JSON payloads are stored in the data attribute (whitespace is not preserved),
+// other payloads in data_base64.
+//
+// Unmarshal accepts events in both binary and structured mode, regardless of Mode.
+type CloudEventsMarshaler struct {
+	// Mode is the content mode used by Marshal, binary mode by default.
+	Mode CloudEventsMode
+
+	// Source is the source attribute used when the message has no "source" metadata.
+	Source string
+
+	// Type is the type attribute used when the message has no "type" metadata.
+	Type string
+
+	// DataContentType is the datacontenttype attribute used when the message has no "datacontenttype" metadata.
+	DataContentType string
+}
+
+// Marshal transforms a watermill message into a CloudEvent.
+func (c *CloudEventsMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, error) {
+	attributes := c.attributes(msg)
+
+	for _, required := range []string{"source", "type"} {
+		if attributes[required] == "" {
+			return nil, errors.Errorf("CloudEvents attribute %q is missing, set it in metadata or CloudEventsMarshaler", required)
+		}
+	}
+
+	if c.Mode == CloudEventsStructuredMode {
+		return c.marshalStructured(topic, msg, attributes)
+	}
+
+	header := make(nats.Header)
+
+	for k, v := range attributes {
+		if k == "datacontenttype" {
+			header.Set(ContentTypeHdr, v)
+			continue
+		}
+		header.Set(CloudEventsHeaderPrefix+k, v)
+	}
+
+	return defaultNatsMsg(topic, msg.Payload, header), nil
+}
+
+func (c *CloudEventsMarshaler) attributes(msg *message.Message) map[string]string {
+	attributes := map[string]string{
+		"specversion": CloudEventsSpecVersion,
+		"id":          msg.UUID,
+	}
+
+	if c.Source != "" {
+		attributes["source"] = c.Source
+	}
+	if c.Type != "" {
+		attributes["type"] = c.Type
+	}
+	if c.DataContentType != "" {
+		attributes["datacontenttype"] = c.DataContentType
+	}
+
+	for k, v := range msg.Metadata {
+		if containsString(cloudEventsIDAttributes, k) || containsString(cloudEventsDataAttributes, k) {
+			continue
+		}
+		attributes[k] = v
+	}
+
+	return attributes
+}
+
+func (c *CloudEventsMarshaler) marshalStructured(topic string, msg *message.Message, attributes map[string]string) (*nats.Msg, error) {
+	event := make(map[string]interface{}, len(attributes)+1)
+	for k, v := range attributes {
+		event[k] = v
+	}
+
+	if len(msg.Payload) > 0 {
+		if isJSONContentType(attributes["datacontenttype"]) && json.Valid(msg.Payload) {
+			event["data"] = json.RawMessage(msg.Payload)
+		} else {
+			event["data_base64"] = base64.StdEncoding.EncodeToString(msg.Payload)
+		}
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot encode CloudEvent")
+	}
+
+	header := make(nats.Header)
+	header.Set(ContentTypeHdr, CloudEventsContentType)
+
+	return defaultNatsMsg(topic, data, header), nil
+}
+
+// Unmarshal extracts a watermill message from a binary or structured mode CloudEvent.
+func (c *CloudEventsMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	if strings.HasPrefix(natsMsg.Header.Get(ContentTypeHdr), "application/cloudevents") {
+		return c.unmarshalStructured(natsMsg)
+	}
+
+	if natsMsg.Header.Get(CloudEventsHeaderPrefix+"specversion") == "" {
+		return nil, errors.New("message is not a CloudEvent, ce-specversion header is missing")
+	}
+
+	var id string
+	md := make(message.Metadata)
+
+	for k, v := range natsMsg.Header {
+		if len(v) == 0 {
+			continue
+		}
+
+		switch {
+		case k == ContentTypeHdr:
+			md.Set("datacontenttype", v[0])
+		case strings.HasPrefix(k, CloudEventsHeaderPrefix):
+			attribute := strings.TrimPrefix(k, CloudEventsHeaderPrefix)
+			if attribute == "id" {
+				id = v[0]
+			} else if !containsString(cloudEventsIDAttributes, attribute) {
+				md.Set(attribute, v[0])
+			}
+		}
+	}
+
+	if id == "" {
+		return nil, &InvalidCloudEventError{Err: ErrCloudEventIDMissing}
+	}
+
+	msg := message.NewMessage(id, natsMsg.Data)
+	msg.Metadata = md
+
+	return msg, nil
+}
+
+func (c *CloudEventsMarshaler) unmarshalStructured(natsMsg *nats.Msg) (*message.Message, error) {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(natsMsg.Data, &event); err != nil {
+		return nil, errors.Wrap(err, "cannot decode CloudEvent")
+	}
+
+	md := make(message.Metadata)
+	var id string
+
+	for k, raw := range event {
+		if containsString(cloudEventsDataAttributes, k) {
+			continue
+		}
+
+		value, err := cloudEventsAttributeValue(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot decode CloudEvent attribute %q", k)
+		}
+
+		if k == "id" {
+			id = value
+		} else if !containsString(cloudEventsIDAttributes, k) {
+			md.Set(k, value)
+		}
+	}
+
+	if id == "" {
+		return nil, &InvalidCloudEventError{Err: ErrCloudEventIDMissing}
+	}
+
+	var payload []byte
+
+	if raw, ok := event["data_base64"]; ok {
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return nil, errors.Wrap(err, "cannot decode CloudEvent data_base64")
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot decode CloudEvent data_base64")
+		}
+		payload = decoded
+	} else if raw, ok := event["data"]; ok {
+		if isJSONContentType(md.Get("datacontenttype")) {
+			payload = raw
+		} else {
+			// non JSON data is stored as JSON string
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				payload = raw
+			} else {
+				payload = []byte(s)
+			}
+		}
+	}
+
+	msg := message.NewMessage(id, payload)
+	msg.Metadata = md
+
+	return msg, nil
+}
+
+// cloudEventsAttributeValue returns the canonical string representation of an attribute value.
+func cloudEventsAttributeValue(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+
+	buf := new(bytes.Buffer)
+	if err := json.Compact(buf, raw); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// isJSONContentType returns true for empty (JSON is the default in structured mode) and JSON content types.
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,104 @@
+package jetstream_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudEventsMarshaler(t *testing.T) {
+	for _, mode := range []jetstream.CloudEventsMode{jetstream.CloudEventsBinaryMode, jetstream.CloudEventsStructuredMode} {
+		for _, payload := range [][]byte{[]byte(`{"order_id":"123"}`), {0xff, 0x00, 0x10}} {
+			t.Run(fmt.Sprintf("mode_%d_%x", mode, payload[0]), func(t *testing.T) {
+				marshaler := &jetstream.CloudEventsMarshaler{
+					Mode:   mode,
+					Source: "/orders",
+					Type:   "com.example.order.placed",
+				}
+
+				msg := message.NewMessage(watermill.NewUUID(), payload)
+				msg.Metadata.Set("tenant", "acme")
+				msg.Metadata.Set("subject", "123")
+
+				natsMsg, err := marshaler.Marshal("topic", msg)
+				require.NoError(t, err)
+
+				if mode == jetstream.CloudEventsBinaryMode {
+					assert.Equal(t, msg.UUID, natsMsg.Header.Get("ce-id"))
+					assert.Equal(t, "acme", natsMsg.Header.Get("ce-tenant"))
+					assert.Equal(t, []byte(msg.Payload), natsMsg.Data)
+				} else {
+					assert.Equal(t, jetstream.CloudEventsContentType, natsMsg.Header.Get(jetstream.ContentTypeHdr))
+				}
+
+				unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+				require.NoError(t, err)
+
+				assert.Equal(t, msg.UUID, unmarshaledMsg.UUID)
+				assert.Equal(t, msg.Payload, unmarshaledMsg.Payload)
+				assert.Equal(t, "acme", unmarshaledMsg.Metadata.Get("tenant"))
+				assert.Equal(t, "123", unmarshaledMsg.Metadata.Get("subject"))
+				assert.Equal(t, "/orders", unmarshaledMsg.Metadata.Get("source"))
+				assert.Equal(t, "com.example.order.placed", unmarshaledMsg.Metadata.Get("type"))
+			})
+		}
+	}
+}
+
+func TestCloudEventsMarshaler_Foreign_Structured_Event(t *testing.T) {
+	natsMsg := nats.NewMsg("topic")
+	natsMsg.Header.Set(jetstream.ContentTypeHdr, "application/cloudevents+json; charset=utf-8")
+	natsMsg.Data = []byte(`{
+		"specversion": "1.0",
+		"id": "A234-1234-1234",
+		"source": "https://github.com/cloudevents/spec/pull",
+		"type": "com.github.pull_request.opened",
+		"datacontenttype": "text/xml",
+		"priority": 5,
+		"data": "<much wow=\"xml\"/>"
+	}`)
+
+	msg, err := (&jetstream.CloudEventsMarshaler{}).Unmarshal(natsMsg)
+	require.NoError(t, err)
+
+	assert.Equal(t, "A234-1234-1234", msg.UUID)
+	assert.Equal(t, `<much wow="xml"/>`, string(msg.Payload))
+	assert.Equal(t, "5", msg.Metadata.Get("priority"))
+	assert.Equal(t, "text/xml", msg.Metadata.Get("datacontenttype"))
+	assert.Empty(t, msg.Metadata.Get("specversion"))
+}
+
+func TestCloudEventsMarshaler_Errors(t *testing.T) {
+	_, err := (&jetstream.CloudEventsMarshaler{}).Marshal("topic", sampleMessage(10))
+	require.Error(t, err, "source and type are required")
+
+	natsMsg, err := (&jetstream.NATSMarshaler{}).Marshal("topic", sampleMessage(10))
+	require.NoError(t, err)
+
+	_, err = (&jetstream.CloudEventsMarshaler{}).Unmarshal(natsMsg)
+	require.Error(t, err, "not a CloudEvent")
+
+	binaryWithoutID := nats.NewMsg("topic")
+	binaryWithoutID.Header.Set("ce-specversion", "1.0")
+	binaryWithoutID.Header.Set("ce-source", "/orders")
+	binaryWithoutID.Header.Set("ce-type", "com.example.order.placed")
+
+	structuredWithoutID := nats.NewMsg("topic")
+	structuredWithoutID.Header.Set(jetstream.ContentTypeHdr, jetstream.CloudEventsContentType)
+	structuredWithoutID.Data = []byte(`{"specversion":"1.0","source":"/orders","type":"com.example.order.placed"}`)
+
+	for _, withoutID := range []*nats.Msg{binaryWithoutID, structuredWithoutID} {
+		_, err = (&jetstream.CloudEventsMarshaler{}).Unmarshal(withoutID)
+		require.ErrorIs(t, err, jetstream.ErrCloudEventIDMissing)
+
+		var invalidErr *jetstream.InvalidCloudEventError
+		require.ErrorAs(t, err, &invalidErr)
+		assert.True(t, invalidErr.Permanent())
+	}
+}
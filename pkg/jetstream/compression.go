@@ -0,0 +1,388 @@
+package jetstream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// ContentEncodingHdr is the header used by CompressionMarshaler to store the compression algorithm.
+const ContentEncodingHdr = "_watermill_content_encoding"
+
+// DefaultMaxDecompressedSize is the default maximum size of decompressed message data (64 MiB).
+const DefaultMaxDecompressedSize = 64 << 20
+
+// Compressor compresses and decompresses message data.
+type Compressor interface {
+	// Encoding returns the name of the algorithm, stored in the ContentEncodingHdr header.
+	Encoding() string
+
+	// Compress returns compressed data.
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress returns decompressed data.
+	Decompress(data []byte) ([]byte, error)
+}
+
+// LimitedDecompressor is implemented by compressors which stop decompressing once the data exceeds limit bytes.
+// Data of other compressors is checked only after it is decompressed.
+type LimitedDecompressor interface {
+	// DecompressLimited returns decompressed data, or DecompressedSizeError when it exceeds limit bytes.
+	DecompressLimited(data []byte, limit int) ([]byte, error)
+}
+
+// DecompressedSizeError is returned when decompressed data exceeds the configured maximum size.
+//
+// It is a PermanentError, so Subscriber terminates such messages instead of letting them be redelivered.
+type DecompressedSizeError struct {
+	Limit int
+}
+
+func (e *DecompressedSizeError) Error() string {
+	return fmt.Sprintf("decompressed data exceeds %d bytes", e.Limit)
+}
+
+// Permanent returns true, redelivery will not change the data.
+func (e *DecompressedSizeError) Permanent() bool {
+	return true
+}
+
+// DecompressionError is returned when the content encoding of a message is not supported or its data cannot be decompressed.
+//
+// It is a PermanentError, so Subscriber terminates such messages instead of letting them be redelivered.
+type DecompressionError struct {
+	Encoding string
+	Err      error
+}
+
+func (e *DecompressionError) Error() string {
+	return "cannot decompress message with " + e.Encoding + ": " + e.Err.Error()
+}
+
+func (e *DecompressionError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent returns true, redelivery will not change the data or the supported encodings.
+func (e *DecompressionError) Permanent() bool {
+	return true
+}
+
+// CompressionMarshaler decorates a MarshalerUnmarshaler to compress nats.Msg.Data.
+//
+// Only data larger than Threshold is compressed. The algorithm is stored in the ContentEncodingHdr header,
+// messages without the header (for example written before compression was enabled) are passed to the
+// decorated Unmarshaler as they are.
+type CompressionMarshaler struct {
+	// Marshaler is the decorated marshaler.
+	Marshaler MarshalerUnmarshaler
+
+	// Compressor is used to compress outgoing messages (defaults to GzipCompressor).
+	Compressor Compressor
+
+	// Threshold is the minimal data size in bytes to compress.
+	Threshold int
+
+	// Decompressors are additional compressors accepted when unmarshaling,
+	// for example to read messages written before changing Compressor.
+	// Gzip, zstd and snappy are always supported.
+	Decompressors []Compressor
+
+	// MaxDecompressedSize is the maximum size in bytes of decompressed data (defaults to DefaultMaxDecompressedSize).
+	// It protects subscribers from small messages decompressing to huge data.
+	MaxDecompressedSize int
+}
+
+// Marshal marshals msg with the decorated marshaler and compresses the data when it is above the threshold.
+func (c *CompressionMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, error) {
+	natsMsg, err := c.Marshaler.Marshal(topic, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(natsMsg.Data) < c.Threshold {
+		return natsMsg, nil
+	}
+
+	compressor := c.compressor()
+
+	data, err := compressor.Compress(natsMsg.Data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot compress message with %s", compressor.Encoding())
+	}
+
+	if natsMsg.Header == nil {
+		natsMsg.Header = make(nats.Header)
+	}
+
+	natsMsg.Header.Set(ContentEncodingHdr, compressor.Encoding())
+	natsMsg.Data = data
+
+	return natsMsg, nil
+}
+
+// Unmarshal decompresses the data based on the ContentEncodingHdr header and unmarshals it with the decorated unmarshaler.
+func (c *CompressionMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	encoding := natsMsg.Header.Get(ContentEncodingHdr)
+	if encoding == "" {
+		return c.Marshaler.Unmarshal(natsMsg)
+	}
+
+	compressor, err := c.decompressor(encoding)
+	if err != nil {
+		return nil, &DecompressionError{Encoding: encoding, Err: err}
+	}
+
+	data, err := decompress(compressor, natsMsg.Data, c.maxDecompressedSize())
+	var sizeErr *DecompressedSizeError
+	if errors.As(err, &sizeErr) {
+		return nil, errors.Wrapf(err, "cannot decompress message with %s", encoding)
+	}
+	if err != nil {
+		return nil, &DecompressionError{Encoding: encoding, Err: err}
+	}
+
+	return c.Marshaler.Unmarshal(withData(natsMsg, data, ContentEncodingHdr))
+}
+
+func (c *CompressionMarshaler) compressor() Compressor {
+	if c.Compressor == nil {
+		return GzipCompressor{}
+	}
+
+	return c.Compressor
+}
+
+func (c *CompressionMarshaler) maxDecompressedSize() int {
+	if c.MaxDecompressedSize <= 0 {
+		return DefaultMaxDecompressedSize
+	}
+
+	return c.MaxDecompressedSize
+}
+
+func (c *CompressionMarshaler) decompressor(encoding string) (Compressor, error) {
+	candidates := append([]Compressor{c.compressor()}, c.Decompressors...)
+	candidates = append(candidates, GzipCompressor{}, ZstdCompressor{}, SnappyCompressor{})
+
+	for _, candidate := range candidates {
+		if candidate.Encoding() == encoding {
+			return candidate, nil
+		}
+	}
+
+	return nil, errors.New("unsupported content encoding")
+}
+
+// decompress decompresses data with compressor, failing when it exceeds limit bytes.
+func decompress(compressor Compressor, data []byte, limit int) ([]byte, error) {
+	if limited, ok := compressor.(LimitedDecompressor); ok {
+		return limited.DecompressLimited(data, limit)
+	}
+
+	decompressed, err := compressor.Decompress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decompressed) > limit {
+		return nil, &DecompressedSizeError{Limit: limit}
+	}
+
+	return decompressed, nil
+}
+
+// withData returns a copy of natsMsg with data replaced and the given headers removed.
+func withData(natsMsg *nats.Msg, data []byte, removeHeaders ...string) *nats.Msg {
+	header := make(nats.Header, len(natsMsg.Header))
+	for k, v := range natsMsg.Header {
+		header[k] = v
+	}
+
+	for _, k := range removeHeaders {
+		header.Del(k)
+	}
+
+	return &nats.Msg{
+		Subject: natsMsg.Subject,
+		Reply:   natsMsg.Reply,
+		Header:  header,
+		Data:    data,
+		Sub:     natsMsg.Sub,
+	}
+}
+
+// GzipCompressor compresses data with gzip.
+type GzipCompressor struct {
+	// Level is the gzip compression level, gzip.DefaultCompression is used when zero.
+	Level int
+}
+
+// Encoding returns "gzip".
+func (GzipCompressor) Encoding() string {
+	return "gzip"
+}
+
+// Compress compresses data with gzip.
+func (g GzipCompressor) Compress(data []byte) ([]byte, error) {
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	buf := new(bytes.Buffer)
+
+	w, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses gzip data up to DefaultMaxDecompressedSize.
+func (g GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	return g.DecompressLimited(data, DefaultMaxDecompressedSize)
+}
+
+// DecompressLimited decompresses gzip data up to limit bytes.
+func (GzipCompressor) DecompressLimited(data []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decompressed) > limit {
+		return nil, &DecompressedSizeError{Limit: limit}
+	}
+
+	return decompressed, nil
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdErr     error
+
+	// zstdDecoders are shared zstd decoders by their maximum decoded size
+	zstdDecoders sync.Map
+)
+
+// ZstdCompressor compresses data with zstd.
+type ZstdCompressor struct{}
+
+// Encoding returns "zstd".
+func (ZstdCompressor) Encoding() string {
+	return "zstd"
+}
+
+// Compress compresses data with zstd.
+func (ZstdCompressor) Compress(data []byte) ([]byte, error) {
+	if err := initZstd(); err != nil {
+		return nil, err
+	}
+
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+// Decompress decompresses zstd data up to DefaultMaxDecompressedSize.
+func (z ZstdCompressor) Decompress(data []byte) ([]byte, error) {
+	return z.DecompressLimited(data, DefaultMaxDecompressedSize)
+}
+
+// DecompressLimited decompresses zstd data up to limit bytes.
+func (ZstdCompressor) DecompressLimited(data []byte, limit int) ([]byte, error) {
+	decoder, err := zstdDecoder(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, &DecompressedSizeError{Limit: limit}
+	}
+
+	return decompressed, err
+}
+
+// initZstd creates the shared zstd encoder, it is safe for concurrent use with EncodeAll.
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+	})
+
+	return zstdErr
+}
+
+// zstdDecoder returns the shared zstd decoder limited to limit bytes, it is safe for concurrent use with DecodeAll.
+func zstdDecoder(limit int) (*zstd.Decoder, error) {
+	if decoder, ok := zstdDecoders.Load(limit); ok {
+		return decoder.(*zstd.Decoder), nil
+	}
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	actual, loaded := zstdDecoders.LoadOrStore(limit, decoder)
+	if loaded {
+		decoder.Close()
+	}
+
+	return actual.(*zstd.Decoder), nil
+}
+
+// SnappyCompressor compresses data with snappy block format.
+type SnappyCompressor struct{}
+
+// Encoding returns "snappy".
+func (SnappyCompressor) Encoding() string {
+	return "snappy"
+}
+
+// Compress compresses data with snappy.
+func (SnappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+// Decompress decompresses snappy data up to DefaultMaxDecompressedSize.
+func (s SnappyCompressor) Decompress(data []byte) ([]byte, error) {
+	return s.DecompressLimited(data, DefaultMaxDecompressedSize)
+}
+
+// DecompressLimited decompresses snappy data up to limit bytes, the size is checked before decompressing.
+func (SnappyCompressor) DecompressLimited(data []byte, limit int) ([]byte, error) {
+	size, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > limit {
+		return nil, &DecompressedSizeError{Limit: limit}
+	}
+
+	return snappy.Decode(nil, data)
+}
@@ -0,0 +1,156 @@
+package jetstream_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressionMarshaler(t *testing.T) {
+	compressors := []jetstream.Compressor{
+		jetstream.GzipCompressor{},
+		jetstream.ZstdCompressor{},
+		jetstream.SnappyCompressor{},
+	}
+
+	for _, compressor := range compressors {
+		t.Run(compressor.Encoding(), func(t *testing.T) {
+			marshaler := &jetstream.CompressionMarshaler{
+				Marshaler:  &jetstream.NATSMarshaler{},
+				Compressor: compressor,
+				Threshold:  1024,
+			}
+
+			msg := message.NewMessage(watermill.NewUUID(), bytes.Repeat([]byte("payload"), 1000))
+			msg.Metadata.Set("foo", "bar")
+
+			natsMsg, err := marshaler.Marshal("topic", msg)
+			require.NoError(t, err)
+
+			assert.Equal(t, compressor.Encoding(), natsMsg.Header.Get(jetstream.ContentEncodingHdr))
+			assert.Less(t, len(natsMsg.Data), len(msg.Payload))
+
+			unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+			require.NoError(t, err)
+
+			assert.True(t, msg.Equals(unmarshaledMsg))
+		})
+	}
+}
+
+func TestCompressionMarshaler_Below_Threshold(t *testing.T) {
+	marshaler := &jetstream.CompressionMarshaler{
+		Marshaler: &jetstream.NATSMarshaler{},
+		Threshold: 1024,
+	}
+
+	msg := sampleMessage(100)
+
+	natsMsg, err := marshaler.Marshal("topic", msg)
+	require.NoError(t, err)
+
+	assert.Empty(t, natsMsg.Header.Get(jetstream.ContentEncodingHdr))
+	assert.Equal(t, []byte(msg.Payload), natsMsg.Data)
+}
+
+func TestCompressionMarshaler_Uncompressed_Messages(t *testing.T) {
+	legacyMarshaler := &jetstream.GobMarshaler{}
+
+	marshaler := &jetstream.CompressionMarshaler{
+		Marshaler:  legacyMarshaler,
+		Compressor: jetstream.ZstdCompressor{},
+	}
+
+	msg := sampleMessage(100)
+
+	natsMsg, err := legacyMarshaler.Marshal("topic", msg)
+	require.NoError(t, err)
+
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+
+	assert.True(t, msg.Equals(unmarshaledMsg))
+}
+
+func TestCompressionMarshaler_Unsupported_Encoding(t *testing.T) {
+	marshaler := &jetstream.CompressionMarshaler{
+		Marshaler: &jetstream.NATSMarshaler{},
+	}
+
+	natsMsg := nats.NewMsg("topic")
+	natsMsg.Header.Set(jetstream.ContentEncodingHdr, "br")
+
+	_, err := marshaler.Unmarshal(natsMsg)
+	require.Error(t, err)
+
+	var decompressionErr *jetstream.DecompressionError
+	require.ErrorAs(t, err, &decompressionErr)
+	assert.True(t, decompressionErr.Permanent())
+}
+
+func TestCompressionMarshaler_Corrupt_Data(t *testing.T) {
+	compressors := []jetstream.Compressor{
+		jetstream.GzipCompressor{},
+		jetstream.ZstdCompressor{},
+		jetstream.SnappyCompressor{},
+	}
+
+	for _, compressor := range compressors {
+		t.Run(compressor.Encoding(), func(t *testing.T) {
+			marshaler := &jetstream.CompressionMarshaler{
+				Marshaler: &jetstream.NATSMarshaler{},
+			}
+
+			natsMsg := nats.NewMsg("topic")
+			natsMsg.Header.Set(jetstream.ContentEncodingHdr, compressor.Encoding())
+			natsMsg.Data = []byte("not compressed")
+
+			_, err := marshaler.Unmarshal(natsMsg)
+			require.Error(t, err)
+
+			var decompressionErr *jetstream.DecompressionError
+			require.ErrorAs(t, err, &decompressionErr)
+			assert.True(t, decompressionErr.Permanent())
+		})
+	}
+}
+
+func TestCompressionMarshaler_Max_Decompressed_Size(t *testing.T) {
+	compressors := []jetstream.Compressor{
+		jetstream.GzipCompressor{},
+		jetstream.ZstdCompressor{},
+		jetstream.SnappyCompressor{},
+	}
+
+	for _, compressor := range compressors {
+		t.Run(compressor.Encoding(), func(t *testing.T) {
+			marshaler := &jetstream.CompressionMarshaler{
+				Marshaler:           &jetstream.NATSMarshaler{},
+				Compressor:          compressor,
+				MaxDecompressedSize: 1024,
+			}
+
+			natsMsg, err := marshaler.Marshal("topic", message.NewMessage(watermill.NewUUID(), make([]byte, 1025)))
+			require.NoError(t, err)
+
+			_, err = marshaler.Unmarshal(natsMsg)
+			require.Error(t, err)
+
+			var sizeErr *jetstream.DecompressedSizeError
+			require.ErrorAs(t, err, &sizeErr)
+			assert.True(t, sizeErr.Permanent())
+
+			natsMsg, err = marshaler.Marshal("topic", message.NewMessage(watermill.NewUUID(), make([]byte, 512)))
+			require.NoError(t, err)
+
+			_, err = marshaler.Unmarshal(natsMsg)
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package jetstream
+
+import (
+	"context"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// ConsumerDescription is the description of ephemeral consumers and of durable consumers with generated names
+// created by Subscriber, CleanupConsumers uses it to find consumers left by crashed subscribers.
+const ConsumerDescription = "watermill-jetstream"
+
+// ephemeralConsumer returns true when the consumer of topic is ephemeral.
+// Queue subscriptions without a durable name use the queue group as the durable name, the consumer is shared.
+func (c SubscriberSubscriptionConfig) ephemeralConsumer(topic string) bool {
+	return c.durableName(topic) == "" && c.QueueGroup == ""
+}
+
+// consumerOptions returns the subscribe options applying the consumer lifecycle settings.
+func (c SubscriberSubscriptionConfig) consumerOptions(topic string) []nats.SubOpt {
+	var opts []nats.SubOpt
+
+	// durable consumers are not marked, nats.go rejects existing durables with a different description
+	if c.ephemeralConsumer(topic) {
+		opts = append(opts, nats.Description(ConsumerDescription))
+	}
+
+	if c.InactiveThreshold > 0 {
+		opts = append(opts, nats.InactiveThreshold(c.InactiveThreshold))
+	}
+
+	return opts
+}
+
+// closeSubscription removes sub, deleting its consumer when it is ephemeral or DeleteDurableConsumers is set.
+func (s *Subscriber) closeSubscription(config *SubscriberSubscriptionConfig, topic string, sub *nats.Subscription) error {
+	if !config.ephemeralConsumer(topic) && !config.DeleteDurableConsumers {
+		return s.detach(sub)
+	}
+
+	info, infoErr := sub.ConsumerInfo()
+
+	if err := sub.Unsubscribe(); err != nil {
+		return err
+	}
+
+	if infoErr != nil {
+		if errors.Is(infoErr, nats.ErrConsumerNotFound) {
+			return nil
+		}
+		return errors.Wrap(infoErr, "cannot get consumer info")
+	}
+
+	// nats.go deletes only consumers created by the subscription, other subscriptions may share the consumer
+	err := s.js.DeleteConsumer(info.Stream, info.Name)
+	if err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+		return errors.Wrapf(err, "cannot delete consumer %s", info.Name)
+	}
+
+	return nil
+}
+
+// detach removes sub, keeping its consumer.
+//
+// nats.go deletes consumers it created on Unsubscribe and Drain, AutoUnsubscribe without a limit
+// removes the subscription in the same way, but never deletes the consumer.
+// The connection is flushed, so messages published afterwards are not pushed to the removed subscription
+// (they would be redelivered only after the ack wait).
+func (s *Subscriber) detach(sub *nats.Subscription) error {
+	if err := sub.AutoUnsubscribe(0); err != nil {
+		return err
+	}
+
+	return s.conn.Flush()
+}
+
+// CleanupConsumersConfig is the configuration of CleanupConsumers.
+type CleanupConsumersConfig struct {
+	// Streams are the streams to clean up, all streams are cleaned up when it is empty.
+	Streams []string
+
+	// InactiveFor is how long a consumer must have no subscription and no deliveries to be removed (defaults to 1h).
+	InactiveFor time.Duration
+
+	// DurableNames are durable consumers to remove when they are inactive, in addition to
+	// consumers created by Subscriber.
+	DurableNames []string
+
+	// DryRun only reports the consumers which would be removed.
+	DryRun bool
+}
+
+func (c *CleanupConsumersConfig) setDefaults() {
+	if c.InactiveFor <= 0 {
+		c.InactiveFor = time.Hour
+	}
+}
+
+// CleanupConsumers removes stale consumers left by subscribers, for example after a crash.
+//
+// Consumers created by Subscriber (see ConsumerDescription) and durable consumers listed in DurableNames
+// are removed when they have no subscription and had no deliveries for InactiveFor.
+// Removed consumers are returned as "{stream}.{consumer}".
+// Streams and consumers are listed with admin, listing errors are returned before anything is removed.
+func CleanupConsumers(
+	ctx context.Context,
+	admin *Admin,
+	config CleanupConsumersConfig,
+	logger watermill.LoggerAdapter,
+) ([]string, error) {
+	config.setDefaults()
+
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+
+	streams := config.Streams
+	if len(streams) == 0 {
+		infos, err := admin.listStreams(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, info := range infos {
+			streams = append(streams, info.Config.Name)
+		}
+	}
+
+	durableNames := make(map[string]struct{}, len(config.DurableNames))
+	for _, name := range config.DurableNames {
+		durableNames[name] = struct{}{}
+	}
+
+	var stale []*nats.ConsumerInfo
+
+	for _, stream := range streams {
+		infos, err := admin.listConsumers(ctx, stream)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot list consumers of stream %s", stream)
+		}
+
+		for _, info := range infos {
+			if isStaleConsumer(info, durableNames, config.InactiveFor) {
+				stale = append(stale, info)
+			}
+		}
+	}
+
+	var removed []string
+
+	for _, info := range stale {
+		logger.Info("Removing stale consumer", watermill.LogFields{
+			"stream":   info.Stream,
+			"consumer": info.Name,
+			"dry_run":  config.DryRun,
+		})
+
+		if !config.DryRun {
+			err := admin.js.DeleteConsumer(info.Stream, info.Name, nats.Context(ctx))
+			if err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+				return removed, errors.Wrapf(err, "cannot delete consumer %s of stream %s", info.Name, info.Stream)
+			}
+		}
+
+		removed = append(removed, info.Stream+"."+info.Name)
+	}
+
+	return removed, nil
+}
+
+func isStaleConsumer(info *nats.ConsumerInfo, durableNames map[string]struct{}, inactiveFor time.Duration) bool {
+	// durable consumers with generated names (see SubscribeBatch) are marked like ephemeral ones
+	if info.Config.Description != ConsumerDescription {
+		if _, ok := durableNames[info.Config.Durable]; !ok || info.Config.Durable == "" {
+			return false
+		}
+	}
+
+	if info.PushBound || info.NumWaiting > 0 {
+		return false
+	}
+
+	lastActive := info.Created
+	if info.Delivered.Last != nil && info.Delivered.Last.After(lastActive) {
+		lastActive = *info.Delivered.Last
+	}
+
+	return time.Since(lastActive) >= inactiveFor
+}
@@ -0,0 +1,246 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriber_Consumer_Cleanup(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	tests := []struct {
+		name                   string
+		durableName            string
+		deleteDurableConsumers bool
+		expectedConsumers      int
+	}{
+		{name: "ephemeral", expectedConsumers: 0},
+		{name: "durable", durableName: "durable", expectedConsumers: 1},
+		{name: "durable deleted", durableName: "durable", deleteDurableConsumers: true, expectedConsumers: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topic := "consumer_cleanup_" + watermill.NewShortUUID()
+			defer func() {
+				_ = js.DeleteStream(topic)
+			}()
+
+			sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+				URL:                    natsURL,
+				Unmarshaler:            &jetstream.NATSMarshaler{},
+				AutoProvision:          true,
+				DurableName:            tt.durableName,
+				DeleteDurableConsumers: tt.deleteDurableConsumers,
+				InactiveThreshold:      time.Minute,
+			}, watermill.NewStdLogger(false, false))
+			require.NoError(t, err)
+			defer sub.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			subCtx, cancelSub := context.WithCancel(ctx)
+			messages, err := sub.Subscribe(subCtx, topic)
+			require.NoError(t, err)
+
+			msg := message.NewMessage(watermill.NewUUID(), nil)
+			require.NoError(t, pub.Publish(topic, msg))
+			requireMessage(t, ctx, messages, msg)
+
+			consumers := streamConsumers(js, topic)
+			require.Len(t, consumers, 1)
+			assert.Equal(t, time.Minute, consumers[0].Config.InactiveThreshold)
+
+			cancelSub()
+			// give the subscriber time to unsubscribe, so kept consumers are not counted too early
+			time.Sleep(200 * time.Millisecond)
+
+			assert.Eventually(t, func() bool {
+				return len(streamConsumers(js, topic)) == tt.expectedConsumers
+			}, 5*time.Second, 50*time.Millisecond)
+		})
+	}
+}
+
+func TestSubscriber_Queue_Group_Consumer_Existing(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	topic := "queue_group_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:     topic,
+		Subjects: []string{topic},
+	})
+	require.NoError(t, err)
+
+	// consumer created by a previous version, without description
+	_, err = js.AddConsumer(topic, &nats.ConsumerConfig{
+		Durable:        "grp",
+		DeliverGroup:   "grp",
+		DeliverSubject: nats.NewInbox(),
+		AckPolicy:      nats.AckExplicitPolicy,
+	})
+	require.NoError(t, err)
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsURL,
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		QueueGroup:    "grp",
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+	require.NoError(t, pub.Publish(topic, msg))
+	requireMessage(t, ctx, messages, msg)
+}
+
+func TestSubscriber_Queue_Group_Consumer_Close(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	topic := "queue_group_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	newSubscriber := func() *jetstream.Subscriber {
+		sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+			URL:           natsURL,
+			Unmarshaler:   &jetstream.NATSMarshaler{},
+			AutoProvision: true,
+			QueueGroup:    "grp",
+		}, watermill.NewStdLogger(false, false))
+		require.NoError(t, err)
+
+		return sub
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	closed := newSubscriber()
+	_, err := closed.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	member := newSubscriber()
+	defer member.Close()
+
+	messages, err := member.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	require.NoError(t, closed.Close())
+
+	_, err = js.ConsumerInfo(topic, "grp")
+	require.NoError(t, err, "consumer shared by the queue group should be kept")
+
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+	require.NoError(t, pub.Publish(topic, msg))
+	requireMessage(t, ctx, messages, msg)
+}
+
+func TestCleanupConsumers(t *testing.T) {
+	_, js, pub := natsTestSetup(t)
+	admin, err := jetstream.NewAdminFromPublisher(pub, jetstream.AdminConfig{})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	stream := "cleanup_" + watermill.NewShortUUID()
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     stream,
+		Subjects: []string{stream},
+	})
+	require.NoError(t, err)
+	defer func() {
+		_ = js.DeleteStream(stream)
+	}()
+
+	orphan, err := js.AddConsumer(stream, &nats.ConsumerConfig{
+		Description:    jetstream.ConsumerDescription,
+		DeliverSubject: nats.NewInbox(),
+		AckPolicy:      nats.AckExplicitPolicy,
+	})
+	require.NoError(t, err)
+
+	foreign, err := js.AddConsumer(stream, &nats.ConsumerConfig{
+		DeliverSubject: nats.NewInbox(),
+		AckPolicy:      nats.AckExplicitPolicy,
+	})
+	require.NoError(t, err)
+
+	// durable consumers with generated names are marked, as the ones of SubscribeBatch
+	generated, err := js.AddConsumer(stream, &nats.ConsumerConfig{
+		Durable:     "watermill_batch_" + watermill.NewShortUUID(),
+		Description: jetstream.ConsumerDescription,
+		AckPolicy:   nats.AckExplicitPolicy,
+	})
+	require.NoError(t, err)
+
+	for _, durable := range []string{"stale", "kept"} {
+		_, err := js.AddConsumer(stream, &nats.ConsumerConfig{
+			Durable:   durable,
+			AckPolicy: nats.AckExplicitPolicy,
+		})
+		require.NoError(t, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	config := jetstream.CleanupConsumersConfig{
+		Streams:      []string{stream},
+		InactiveFor:  time.Millisecond,
+		DurableNames: []string{"stale"},
+		DryRun:       true,
+	}
+
+	expected := []string{stream + "." + orphan.Name, stream + "." + generated.Name, stream + ".stale"}
+
+	removed, err := jetstream.CleanupConsumers(ctx, admin, config, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, expected, removed)
+	assert.Len(t, streamConsumers(js, stream), 5, "dry run should not remove consumers")
+
+	config.DryRun = false
+
+	removed, err = jetstream.CleanupConsumers(ctx, admin, config, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, expected, removed)
+
+	var remaining []string
+	for _, info := range streamConsumers(js, stream) {
+		remaining = append(remaining, info.Name)
+	}
+	assert.ElementsMatch(t, []string{foreign.Name, "kept"}, remaining)
+
+	config.Streams = []string{stream, "missing_" + watermill.NewShortUUID()}
+
+	removed, err = jetstream.CleanupConsumers(ctx, admin, config, nil)
+	require.ErrorIs(t, err, nats.ErrStreamNotFound, "listing errors should be reported")
+	assert.Empty(t, removed)
+}
+
+func streamConsumers(js nats.JetStreamManager, stream string) []*nats.ConsumerInfo {
+	var consumers []*nats.ConsumerInfo
+	for info := range js.ConsumersInfo(stream) {
+		consumers = append(consumers, info)
+	}
+
+	return consumers
+}
@@ -0,0 +1,59 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriber_Durable_Name_Calculator(t *testing.T) {
+	natsURL, js, _ := natsTestSetup(t)
+
+	stream := "shared_" + watermill.NewShortUUID()
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:     stream,
+		Subjects: []string{stream + ".>"},
+	})
+	require.NoError(t, err)
+	defer func() {
+		_ = js.DeleteStream(stream)
+	}()
+
+	pub, err := jetstream.NewPublisher(jetstream.PublisherConfig{
+		URL:       natsURL,
+		Marshaler: &jetstream.NATSMarshaler{},
+	}, nil)
+	require.NoError(t, err)
+	defer pub.Close()
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:                   natsURL,
+		Unmarshaler:           &jetstream.NATSMarshaler{},
+		DurableName:           "group",
+		DurableNameCalculator: jetstream.TopicDurableNameCalculator,
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// both topics are stored in the same stream, so they need distinct consumers
+	for _, topic := range []string{stream + ".orders", stream + ".payments"} {
+		messages, err := sub.Subscribe(ctx, topic)
+		require.NoError(t, err)
+
+		msg := message.NewMessage(watermill.NewUUID(), []byte(topic))
+		require.NoError(t, pub.Publish(topic, msg))
+		requireMessage(t, ctx, messages, msg)
+
+		_, err = js.ConsumerInfo(stream, jetstream.TopicDurableNameCalculator(topic, "group"))
+		require.NoError(t, err)
+	}
+}
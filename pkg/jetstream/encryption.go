@@ -0,0 +1,333 @@
+package jetstream
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// EncryptionKeyIDHdr is the header used by EncryptionMarshaler to store the ID of the key encrypting the data key.
+	EncryptionKeyIDHdr = "_watermill_encryption_key_id"
+
+	// EncryptionDataKeyHdr is the header used by EncryptionMarshaler to store the encrypted data key.
+	EncryptionDataKeyHdr = "_watermill_encryption_data_key"
+
+	// EncryptedMetadataHdr is the header used by EncryptionMarshaler to store encrypted headers,
+	// when EncryptMetadata is enabled.
+	EncryptedMetadataHdr = "_watermill_encrypted_metadata"
+)
+
+// dataKeySize is the size of the per-message AES-256 data key.
+const dataKeySize = 32
+
+// KeyProvider provides the key encryption keys used by EncryptionMarshaler.
+//
+// Keys must be 16, 24 or 32 bytes long (AES-128, AES-192 or AES-256).
+type KeyProvider interface {
+	// CurrentKey returns the key used to encrypt new messages and its ID.
+	CurrentKey() (id string, key []byte, err error)
+
+	// Key returns the key with the given ID, used to decrypt messages.
+	Key(id string) ([]byte, error)
+}
+
+// KeyRing is a KeyProvider holding several active keys in memory.
+//
+// Keys can be rotated by adding a new key and making it current,
+// messages encrypted with previous keys are still decrypted as long as the keys are in the ring.
+type KeyRing struct {
+	lock      sync.RWMutex
+	currentID string
+	keys      map[string][]byte
+}
+
+// NewKeyRing creates a new KeyRing with currentID used to encrypt new messages.
+func NewKeyRing(currentID string, keys map[string][]byte) (*KeyRing, error) {
+	k := &KeyRing{keys: make(map[string][]byte, len(keys))}
+
+	for id, key := range keys {
+		if err := k.AddKey(id, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := k.SetCurrent(currentID); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// AddKey adds a key to the ring.
+func (k *KeyRing) AddKey(id string, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return errors.Wrapf(err, "invalid key %q", id)
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.keys[id] = key
+
+	return nil
+}
+
+// RemoveKey removes a key from the ring, messages encrypted with it can't be decrypted anymore.
+func (k *KeyRing) RemoveKey(id string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if id == k.currentID {
+		return errors.Errorf("cannot remove current key %q", id)
+	}
+
+	delete(k.keys, id)
+
+	return nil
+}
+
+// SetCurrent sets the key used to encrypt new messages.
+func (k *KeyRing) SetCurrent(id string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if _, ok := k.keys[id]; !ok {
+		return errors.Errorf("key %q not found", id)
+	}
+
+	k.currentID = id
+
+	return nil
+}
+
+// CurrentKey returns the current key.
+func (k *KeyRing) CurrentKey() (string, []byte, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	return k.currentID, k.keys[k.currentID], nil
+}
+
+// Key returns the key with the given ID.
+func (k *KeyRing) Key(id string) ([]byte, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, errors.Errorf("key %q not found", id)
+	}
+
+	return key, nil
+}
+
+// EncryptionMarshaler decorates a MarshalerUnmarshaler to encrypt nats.Msg.Data with AES-GCM envelope encryption.
+//
+// Every message is encrypted with a random data key, which is encrypted with the current key of KeyProvider.
+// The key ID and the encrypted data key are stored in headers.
+type EncryptionMarshaler struct {
+	// Marshaler is the decorated marshaler.
+	Marshaler MarshalerUnmarshaler
+
+	// KeyProvider provides keys used to encrypt data keys.
+	KeyProvider KeyProvider
+
+	// EncryptMetadata enables encryption of the headers set by the decorated marshaler (for example metadata
+	// of NATSMarshaler). Reserved NATS headers and the watermill UUID header are not encrypted.
+	EncryptMetadata bool
+
+	// AllowUnencrypted allows to unmarshal messages which were not encrypted,
+	// for example written before encryption was enabled.
+	AllowUnencrypted bool
+}
+
+// Marshal marshals msg with the decorated marshaler and encrypts the data (and optionally headers).
+func (e *EncryptionMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, error) {
+	natsMsg, err := e.Marshaler.Marshal(topic, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, key, err := e.KeyProvider.CurrentKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get current encryption key")
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, errors.Wrap(err, "cannot generate data key")
+	}
+
+	encryptedDataKey, err := aesGCMEncrypt(key, dataKey, []byte(keyID))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot encrypt data key")
+	}
+
+	data, err := aesGCMEncrypt(dataKey, natsMsg.Data, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot encrypt data")
+	}
+
+	header := make(nats.Header)
+
+	if e.EncryptMetadata {
+		plainHeader := make(nats.Header)
+
+		for k, v := range natsMsg.Header {
+			if isReservedHeader(k) {
+				header[k] = v
+			} else {
+				plainHeader[k] = v
+			}
+		}
+
+		if len(plainHeader) > 0 {
+			encryptedHeader, err := encryptHeader(dataKey, plainHeader)
+			if err != nil {
+				return nil, err
+			}
+
+			header.Set(EncryptedMetadataHdr, encryptedHeader)
+		}
+	} else {
+		for k, v := range natsMsg.Header {
+			header[k] = v
+		}
+	}
+
+	header.Set(EncryptionKeyIDHdr, keyID)
+	header.Set(EncryptionDataKeyHdr, base64.StdEncoding.EncodeToString(encryptedDataKey))
+
+	natsMsg.Header = header
+	natsMsg.Data = data
+
+	return natsMsg, nil
+}
+
+// Unmarshal decrypts the data (and headers) and unmarshals it with the decorated unmarshaler.
+func (e *EncryptionMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	keyID := natsMsg.Header.Get(EncryptionKeyIDHdr)
+	if keyID == "" {
+		if e.AllowUnencrypted {
+			return e.Marshaler.Unmarshal(natsMsg)
+		}
+		return nil, errors.New("message is not encrypted")
+	}
+
+	key, err := e.KeyProvider.Key(keyID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get encryption key %q", keyID)
+	}
+
+	encryptedDataKey, err := base64.StdEncoding.DecodeString(natsMsg.Header.Get(EncryptionDataKeyHdr))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decode data key")
+	}
+
+	dataKey, err := aesGCMDecrypt(key, encryptedDataKey, []byte(keyID))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decrypt data key")
+	}
+
+	data, err := aesGCMDecrypt(dataKey, natsMsg.Data, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decrypt data")
+	}
+
+	decrypted := withData(natsMsg, data, EncryptionKeyIDHdr, EncryptionDataKeyHdr, EncryptedMetadataHdr)
+
+	if encryptedHeader := natsMsg.Header.Get(EncryptedMetadataHdr); encryptedHeader != "" {
+		plainHeader, err := decryptHeader(dataKey, encryptedHeader)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range plainHeader {
+			decrypted.Header[k] = v
+		}
+	}
+
+	return e.Marshaler.Unmarshal(decrypted)
+}
+
+func encryptHeader(dataKey []byte, header nats.Header) (string, error) {
+	plain, err := json.Marshal(header)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot encode metadata")
+	}
+
+	encrypted, err := aesGCMEncrypt(dataKey, plain, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot encrypt metadata")
+	}
+
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+func decryptHeader(dataKey []byte, encoded string) (nats.Header, error) {
+	encrypted, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decode metadata")
+	}
+
+	plain, err := aesGCMDecrypt(dataKey, encrypted, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decrypt metadata")
+	}
+
+	header := make(nats.Header)
+	if err := json.Unmarshal(plain, &header); err != nil {
+		return nil, errors.Wrap(err, "cannot decode metadata")
+	}
+
+	return header, nil
+}
+
+// aesGCMEncrypt encrypts plaintext and returns nonce followed by the ciphertext.
+func aesGCMEncrypt(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// aesGCMDecrypt decrypts data created by aesGCMEncrypt.
+func aesGCMDecrypt(key, data, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,108 @@
+package jetstream_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKeyRing = mustNewKeyRing("key-1", map[string][]byte{"key-1": bytes.Repeat([]byte{1}, 32)})
+
+func mustNewKeyRing(currentID string, keys map[string][]byte) *jetstream.KeyRing {
+	keyRing, err := jetstream.NewKeyRing(currentID, keys)
+	if err != nil {
+		panic(err)
+	}
+	return keyRing
+}
+
+func TestEncryptionMarshaler_Key_Rotation(t *testing.T) {
+	keyRing := mustNewKeyRing("key-1", map[string][]byte{"key-1": bytes.Repeat([]byte{1}, 32)})
+
+	marshaler := &jetstream.EncryptionMarshaler{
+		Marshaler:   &jetstream.NATSMarshaler{},
+		KeyProvider: keyRing,
+	}
+
+	oldMsg := sampleMessage(100)
+	oldNatsMsg, err := marshaler.Marshal("topic", oldMsg)
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", oldNatsMsg.Header.Get(jetstream.EncryptionKeyIDHdr))
+	assert.NotEqual(t, []byte(oldMsg.Payload), oldNatsMsg.Data)
+
+	require.NoError(t, keyRing.AddKey("key-2", bytes.Repeat([]byte{2}, 16)))
+	require.NoError(t, keyRing.SetCurrent("key-2"))
+
+	newMsg := sampleMessage(100)
+	newNatsMsg, err := marshaler.Marshal("topic", newMsg)
+	require.NoError(t, err)
+	assert.Equal(t, "key-2", newNatsMsg.Header.Get(jetstream.EncryptionKeyIDHdr))
+
+	for _, tc := range []struct {
+		msg     *message.Message
+		natsMsg *nats.Msg
+	}{{oldMsg, oldNatsMsg}, {newMsg, newNatsMsg}} {
+		unmarshaledMsg, err := marshaler.Unmarshal(tc.natsMsg)
+		require.NoError(t, err)
+		assert.True(t, tc.msg.Equals(unmarshaledMsg))
+	}
+
+	require.NoError(t, keyRing.RemoveKey("key-1"))
+
+	_, err = marshaler.Unmarshal(oldNatsMsg)
+	require.Error(t, err)
+}
+
+func TestEncryptionMarshaler_Metadata(t *testing.T) {
+	msg := sampleMessage(100)
+
+	for _, encryptMetadata := range []bool{true, false} {
+		t.Run(fmt.Sprintf("encrypt_metadata_%t", encryptMetadata), func(t *testing.T) {
+			marshaler := &jetstream.EncryptionMarshaler{
+				Marshaler:       &jetstream.NATSMarshaler{},
+				KeyProvider:     testKeyRing,
+				EncryptMetadata: encryptMetadata,
+			}
+
+			natsMsg, err := marshaler.Marshal("topic", msg)
+			require.NoError(t, err)
+
+			assert.Equal(t, msg.UUID, natsMsg.Header.Get(jetstream.WatermillUUIDHdr))
+
+			for k, v := range msg.Metadata {
+				if encryptMetadata {
+					assert.Empty(t, natsMsg.Header.Get(k))
+				} else {
+					assert.Equal(t, v, natsMsg.Header.Get(k))
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptionMarshaler_Unencrypted_Messages(t *testing.T) {
+	msg := sampleMessage(100)
+
+	natsMsg, err := (&jetstream.NATSMarshaler{}).Marshal("topic", msg)
+	require.NoError(t, err)
+
+	marshaler := &jetstream.EncryptionMarshaler{
+		Marshaler:   &jetstream.NATSMarshaler{},
+		KeyProvider: testKeyRing,
+	}
+
+	_, err = marshaler.Unmarshal(natsMsg)
+	require.Error(t, err)
+
+	marshaler.AllowUnencrypted = true
+
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+	assert.True(t, msg.Equals(unmarshaledMsg))
+}
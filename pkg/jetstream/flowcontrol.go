@@ -0,0 +1,96 @@
+package jetstream
+
+import (
+	"context"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/nats-io/nats.go"
+)
+
+// flowControlOptions returns the subscribe options applying the backpressure settings to the consumer.
+func (c SubscriberSubscriptionConfig) flowControlOptions() []nats.SubOpt {
+	var opts []nats.SubOpt
+
+	if c.MaxAckPending > 0 {
+		opts = append(opts, nats.MaxAckPending(c.MaxAckPending))
+	}
+
+	if c.IdleHeartbeat > 0 {
+		opts = append(opts, nats.IdleHeartbeat(c.IdleHeartbeat), nats.EnableFlowControl())
+	}
+
+	return opts
+}
+
+// setPendingLimits applies the configured pending limits to sub, keeping the current value of the limit not set.
+func (c SubscriberSubscriptionConfig) setPendingLimits(sub *nats.Subscription) error {
+	if c.PendingMsgsLimit == 0 && c.PendingBytesLimit == 0 {
+		return nil
+	}
+
+	msgsLimit, bytesLimit, err := sub.PendingLimits()
+	if err != nil {
+		return err
+	}
+
+	if c.PendingMsgsLimit != 0 {
+		msgsLimit = c.PendingMsgsLimit
+	}
+	if c.PendingBytesLimit != 0 {
+		bytesLimit = c.PendingBytesLimit
+	}
+
+	return sub.SetPendingLimits(msgsLimit, bytesLimit)
+}
+
+// inFlightLimiter limits the number of messages processed at once by a single Subscribe call.
+// A nil limiter doesn't limit anything.
+type inFlightLimiter chan struct{}
+
+func newInFlightLimiter(limit int) inFlightLimiter {
+	if limit <= 0 {
+		return nil
+	}
+
+	return make(inFlightLimiter, limit)
+}
+
+// acquire blocks until a message can be processed, it returns false when ctx is done or closing is closed first.
+func (l inFlightLimiter) acquire(ctx context.Context, closing chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+
+	select {
+	case l <- struct{}{}:
+		return true
+	case <-closing:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l inFlightLimiter) release() {
+	if l == nil {
+		return
+	}
+
+	<-l
+}
+
+func (s *Subscriber) logSlowConsumer(sub *nats.Subscription, err error, logFields watermill.LogFields) {
+	pendingMsgs, pendingBytes, pendingErr := sub.Pending()
+	if pendingErr == nil {
+		logFields = logFields.Add(watermill.LogFields{
+			"pending_msgs":  pendingMsgs,
+			"pending_bytes": pendingBytes,
+		})
+	}
+
+	if dropped, droppedErr := sub.Dropped(); droppedErr == nil {
+		logFields = logFields.Add(watermill.LogFields{"dropped_msgs": dropped})
+	}
+
+	s.logger.Error("Slow consumer, messages were dropped", err, logFields)
+}
@@ -0,0 +1,94 @@
+package jetstream_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriber_Backpressure(t *testing.T) {
+	_, js := natsTestConn(t)
+
+	topic := "backpressure_" + watermill.NewShortUUID()
+	durableName := "durable_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	logger := watermill.NewStdLogger(false, false)
+
+	pub := newTestPublisher(t, nil)
+
+	// keys of the first messages are routed to different workers, so only the in-flight limit holds them back
+	for i := 0; i < 5; i++ {
+		msg := message.NewMessage(watermill.NewUUID(), nil)
+		msg.Metadata.Set("key", strconv.Itoa(i))
+		require.NoError(t, pub.Publish(topic, msg))
+	}
+
+	const maxAckPending = 2
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:               natsTestURL(),
+		Unmarshaler:       &jetstream.NATSMarshaler{},
+		AutoProvision:     true,
+		DurableName:       durableName,
+		Workers:           4,
+		PartitionKey:      jetstream.MetadataPartitionKey("key"),
+		MaxAckPending:     maxAckPending,
+		PendingMsgsLimit:  100,
+		PendingBytesLimit: 1024 * 1024,
+		IdleHeartbeat:     time.Second,
+	}, logger)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	info, err := js.ConsumerInfo(topic, durableName)
+	require.NoError(t, err)
+	assert.Equal(t, maxAckPending, info.Config.MaxAckPending)
+	assert.Equal(t, time.Second, info.Config.Heartbeat)
+	assert.True(t, info.Config.FlowControl)
+
+	var inFlight []*message.Message
+	for i := 0; i < maxAckPending; i++ {
+		select {
+		case msg := <-messages:
+			inFlight = append(inFlight, msg)
+		case <-ctx.Done():
+			t.Fatal("message not received")
+		}
+	}
+
+	select {
+	case msg := <-messages:
+		t.Fatalf("message %s received over MaxAckPending", msg.UUID)
+	case <-time.After(time.Second):
+		// expected, no more messages are delivered until acked
+	}
+
+	for _, msg := range inFlight {
+		msg.Ack()
+	}
+
+	for i := 0; i < 5-maxAckPending; i++ {
+		select {
+		case msg := <-messages:
+			msg.Ack()
+		case <-ctx.Done():
+			t.Fatal("message not received after ack")
+		}
+	}
+}
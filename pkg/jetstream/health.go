@@ -0,0 +1,154 @@
+package jetstream
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// heartbeatMissThreshold is the number of heartbeat intervals without a "consumer not active" error
+// after which heartbeats are considered to be flowing again.
+const heartbeatMissThreshold = 3
+
+// HealthChecker is implemented by components which are able to report their health.
+type HealthChecker interface {
+	// Health reports the current state of the component.
+	Health(ctx context.Context) HealthStatus
+}
+
+// HealthStatus describes the state of a Publisher or Subscriber.
+type HealthStatus struct {
+	// Connected is true when the underlying NATS connection is connected.
+	Connected bool `json:"connected"`
+
+	// ConnectionStatus is the textual status of the underlying NATS connection.
+	ConnectionStatus string `json:"connection_status"`
+
+	// JetStreamReachable is true when the JetStream account information could be retrieved.
+	JetStreamReachable bool `json:"jetstream_reachable"`
+
+	// Error contains the reason why JetStream is not reachable.
+	Error string `json:"error,omitempty"`
+
+	// Subscriptions contains the health of every active subscription. It is always empty for Publisher.
+	Subscriptions []SubscriptionHealth `json:"subscriptions,omitempty"`
+}
+
+// Healthy returns true when the connection is up, JetStream is reachable and all subscriptions are healthy.
+func (h HealthStatus) Healthy() bool {
+	if !h.Connected || !h.JetStreamReachable {
+		return false
+	}
+
+	for _, sub := range h.Subscriptions {
+		if !sub.Healthy() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SubscriptionHealth describes the state of a single JetStream subscription.
+type SubscriptionHealth struct {
+	// Topic is the watermill topic of the subscription.
+	Topic string `json:"topic"`
+
+	// Stream is the name of the stream the consumer belongs to.
+	Stream string `json:"stream,omitempty"`
+
+	// Consumer is the name of the JetStream consumer.
+	Consumer string `json:"consumer,omitempty"`
+
+	// ConsumerExists is false when the consumer was removed from the server.
+	ConsumerExists bool `json:"consumer_exists"`
+
+	// NumPending is the number of messages in the stream not yet delivered to the consumer.
+	NumPending uint64 `json:"num_pending"`
+
+	// NumAckPending is the number of messages delivered, but not yet acknowledged.
+	NumAckPending int `json:"num_ack_pending"`
+
+	// NumRedelivered is the number of messages which were redelivered.
+	NumRedelivered int `json:"num_redelivered"`
+
+	// HeartbeatInterval is the idle heartbeat interval of the consumer, zero when heartbeats are disabled.
+	HeartbeatInterval time.Duration `json:"heartbeat_interval"`
+
+	// HeartbeatsFlowing is true when heartbeats are enabled and no missed heartbeats were reported recently.
+	HeartbeatsFlowing bool `json:"heartbeats_flowing"`
+
+	// LastDelivery is the time of the last delivery to the consumer.
+	LastDelivery *time.Time `json:"last_delivery,omitempty"`
+
+	// Error contains the reason why the consumer state could not be retrieved.
+	Error string `json:"error,omitempty"`
+}
+
+// Healthy returns true when the consumer exists and heartbeats (when enabled) are flowing.
+func (h SubscriptionHealth) Healthy() bool {
+	if !h.ConsumerExists || h.Error != "" {
+		return false
+	}
+
+	if h.HeartbeatInterval > 0 && !h.HeartbeatsFlowing {
+		return false
+	}
+
+	return true
+}
+
+// connectionHealth returns the health of the connection and JetStream account.
+func connectionHealth(ctx context.Context, conn *nats.Conn, js nats.JetStreamManager) HealthStatus {
+	status := HealthStatus{
+		Connected:        conn.IsConnected(),
+		ConnectionStatus: conn.Status().String(),
+	}
+
+	if !status.Connected {
+		status.Error = "not connected"
+		return status
+	}
+
+	if _, err := js.AccountInfo(nats.Context(ctx)); err != nil {
+		status.Error = errors.Wrap(err, "cannot get JetStream account info").Error()
+		return status
+	}
+
+	status.JetStreamReachable = true
+
+	return status
+}
+
+// NewHealthHandler creates a http.Handler reporting the health of all provided checkers.
+//
+// It responds with 200 when all checkers are healthy and with 503 otherwise.
+// The response body contains the JSON encoded HealthStatus of every checker.
+func NewHealthHandler(checkers ...HealthChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]HealthStatus, 0, len(checkers))
+		healthy := true
+
+		for _, checker := range checkers {
+			status := checker.Health(r.Context())
+			if !status.Healthy() {
+				healthy = false
+			}
+			statuses = append(statuses, status)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		_ = json.NewEncoder(w).Encode(statuses)
+	})
+}
@@ -0,0 +1,147 @@
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublisher_Health(t *testing.T) {
+	pub := newTestPublisher(t, nil)
+
+	assert.True(t, pub.Ready())
+
+	status := pub.Health(context.Background())
+	assert.True(t, status.Healthy(), "status: %+v", status)
+	assert.Empty(t, status.Subscriptions)
+
+	require.NoError(t, pub.Close())
+
+	assert.False(t, pub.Ready())
+
+	status = pub.Health(context.Background())
+	assert.False(t, status.Connected)
+	assert.False(t, status.Healthy())
+}
+
+func TestSubscriber_Health(t *testing.T) {
+	_, js, pub := natsTestSetup(t)
+
+	topic := "health_" + watermill.NewShortUUID()
+	durableName := "durable_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	const heartbeat = 500 * time.Millisecond
+
+	heartbeatsMissed := make(chan struct{}, 1)
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL: natsTestURL(),
+		NatsOptions: []nats.Option{
+			// the subscriber keeps the error handler of the connection
+			nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+				if errors.Is(err, nats.ErrConsumerNotActive) {
+					select {
+					case heartbeatsMissed <- struct{}{}:
+					default:
+					}
+				}
+			}),
+		},
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		DurableName:   durableName,
+		MaxAckPending: 1,
+		IdleHeartbeat: heartbeat,
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	assert.True(t, sub.Ready())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+	}
+
+	// the first message is not acked, so the others are pending
+	select {
+	case <-messages:
+	case <-ctx.Done():
+		t.Fatal("message not received")
+	}
+
+	subscriptionHealth := func() jetstream.SubscriptionHealth {
+		status := sub.Health(ctx)
+		require.True(t, status.Connected)
+		require.True(t, status.JetStreamReachable)
+		require.Len(t, status.Subscriptions, 1)
+
+		return status.Subscriptions[0]
+	}
+
+	assert.Eventually(t, func() bool {
+		health := subscriptionHealth()
+		return health.NumAckPending == 1 && health.NumPending == 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	health := subscriptionHealth()
+	assert.Equal(t, topic, health.Topic)
+	assert.Equal(t, topic, health.Stream)
+	assert.Equal(t, durableName, health.Consumer)
+	assert.True(t, health.ConsumerExists)
+	assert.Equal(t, heartbeat, health.HeartbeatInterval)
+	assert.True(t, health.HeartbeatsFlowing)
+	assert.True(t, sub.Health(ctx).Healthy())
+
+	info, err := js.ConsumerInfo(topic, durableName)
+	require.NoError(t, err)
+	require.NoError(t, js.DeleteConsumer(topic, durableName))
+
+	select {
+	case <-heartbeatsMissed:
+	case <-ctx.Done():
+		t.Fatal("missed heartbeats not reported")
+	}
+
+	health = subscriptionHealth()
+	assert.False(t, health.ConsumerExists)
+	assert.False(t, sub.Health(ctx).Healthy())
+
+	// the consumer is recreated with the same deliver subject, heartbeats reach the subscription again
+	_, err = js.AddConsumer(topic, &info.Config)
+	require.NoError(t, err)
+
+	health = subscriptionHealth()
+	assert.True(t, health.ConsumerExists)
+	assert.False(t, health.HeartbeatsFlowing, "heartbeats were missed recently")
+	assert.False(t, sub.Health(ctx).Healthy())
+
+	assert.Eventually(t, func() bool {
+		return sub.Health(ctx).Healthy()
+	}, 10*time.Second, 100*time.Millisecond)
+
+	cancelledCtx, cancelCtx := context.WithCancel(context.Background())
+	cancelCtx()
+
+	health = sub.Health(cancelledCtx).Subscriptions[0]
+	assert.Equal(t, context.Canceled.Error(), health.Error)
+
+	require.NoError(t, sub.Close())
+	assert.False(t, sub.Ready())
+}
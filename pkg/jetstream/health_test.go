@@ -0,0 +1,69 @@
+package jetstream
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type staticHealthChecker HealthStatus
+
+func (c staticHealthChecker) Health(ctx context.Context) HealthStatus {
+	return HealthStatus(c)
+}
+
+func TestHealthStatus_Healthy(t *testing.T) {
+	healthySub := SubscriptionHealth{ConsumerExists: true}
+
+	tests := []struct {
+		name    string
+		status  HealthStatus
+		healthy bool
+	}{
+		{name: "OK", status: HealthStatus{Connected: true, JetStreamReachable: true}, healthy: true},
+		{name: "OK - Subscriptions", status: HealthStatus{Connected: true, JetStreamReachable: true, Subscriptions: []SubscriptionHealth{healthySub}}, healthy: true},
+		{name: "OK - Heartbeats flowing", status: HealthStatus{Connected: true, JetStreamReachable: true, Subscriptions: []SubscriptionHealth{{ConsumerExists: true, HeartbeatInterval: time.Second, HeartbeatsFlowing: true}}}, healthy: true},
+		{name: "Unhealthy - Disconnected", status: HealthStatus{Connected: false, JetStreamReachable: true}, healthy: false},
+		{name: "Unhealthy - JetStream unreachable", status: HealthStatus{Connected: true, JetStreamReachable: false}, healthy: false},
+		{name: "Unhealthy - Consumer missing", status: HealthStatus{Connected: true, JetStreamReachable: true, Subscriptions: []SubscriptionHealth{healthySub, {}}}, healthy: false},
+		{name: "Unhealthy - Heartbeats missed", status: HealthStatus{Connected: true, JetStreamReachable: true, Subscriptions: []SubscriptionHealth{{ConsumerExists: true, HeartbeatInterval: time.Second}}}, healthy: false},
+		{name: "Unhealthy - Consumer info error", status: HealthStatus{Connected: true, JetStreamReachable: true, Subscriptions: []SubscriptionHealth{{ConsumerExists: true, Error: "timeout"}}}, healthy: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.healthy, tt.status.Healthy())
+		})
+	}
+}
+
+func TestNewHealthHandler(t *testing.T) {
+	healthy := staticHealthChecker{Connected: true, JetStreamReachable: true}
+	unhealthy := staticHealthChecker{Connected: false}
+
+	tests := []struct {
+		name       string
+		checkers   []HealthChecker
+		wantStatus int
+	}{
+		{name: "OK", checkers: []HealthChecker{healthy, healthy}, wantStatus: http.StatusOK},
+		{name: "Unavailable", checkers: []HealthChecker{healthy, unhealthy}, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+
+			NewHealthHandler(tt.checkers...).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			require.Equal(t, tt.wantStatus, rec.Code)
+
+			var statuses []HealthStatus
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+			require.Len(t, statuses, len(tt.checkers))
+		})
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/nats-io/nats.go"
@@ -28,6 +31,20 @@ type MarshalerUnmarshaler interface {
 	Unmarshaler
 }
 
+// PermanentError is implemented by unmarshal errors which will not be resolved by redelivery.
+//
+// Subscriber terminates messages which failed to unmarshal with a permanent error,
+// instead of letting them be redelivered.
+type PermanentError interface {
+	error
+	Permanent() bool
+}
+
+func isPermanentError(err error) bool {
+	var permanent PermanentError
+	return errors.As(err, &permanent) && permanent.Permanent()
+}
+
 func defaultNatsMsg(topic string, data []byte, hdr nats.Header) *nats.Msg {
 	return &nats.Msg{
 		Subject: topic,
@@ -102,21 +119,94 @@ func (JSONMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
 	return msg, nil
 }
 
+// MultiValueHeaderStrategy determines how NATSMarshaler maps NATS headers with multiple values to metadata.
+type MultiValueHeaderStrategy int
+
+const (
+	// MultiValueHeaderError rejects messages with multiple values in a header with MultipleHeaderValuesError.
+	MultiValueHeaderError MultiValueHeaderStrategy = iota
+
+	// MultiValueHeaderJoin joins the values with NATSMarshaler.MultiValueSeparator.
+	// Marshal splits joined values back into multiple header values, values containing the separator
+	// before joining are split at it too.
+	MultiValueHeaderJoin
+
+	// MultiValueHeaderFirst keeps the first value.
+	MultiValueHeaderFirst
+
+	// MultiValueHeaderLast keeps the last value.
+	MultiValueHeaderLast
+
+	// MultiValueHeaderJSON encodes the values as a JSON array of strings.
+	// Marshal decodes encoded values back into multiple header values.
+	MultiValueHeaderJSON
+)
+
+// MultiValueHeadersMetadataKey is the metadata key listing (as a JSON array) the headers whose values were
+// joined or encoded by NATSMarshaler. Only these values are split into multiple header values by Marshal,
+// other metadata values are kept as they are, even when they contain the separator.
+const MultiValueHeadersMetadataKey = "_watermill_multi_value_headers"
+
+// MultipleHeaderValuesError is returned by NATSMarshaler when a header has multiple values and
+// MultiValueHeaderError strategy is used.
+//
+// It is not permanent, such messages are redelivered, as before multi-value strategies were supported.
+type MultipleHeaderValuesError struct {
+	Header string
+	Values []string
+}
+
+func (e *MultipleHeaderValuesError) Error() string {
+	return fmt.Sprintf("multiple values received in NATS header for %q: (%+v)", e.Header, e.Values)
+}
+
+// Permanent returns false, so messages are redelivered (for example to subscribers using another strategy).
+func (e *MultipleHeaderValuesError) Permanent() bool {
+	return false
+}
+
 // NATSMarshaler uses NATS header to marshal directly between watermill and NATS formats.
 // The watermill UUID is stored at _watermill_message_uuid
-type NATSMarshaler struct{}
+type NATSMarshaler struct {
+	// MultiValueHeaders determines how headers with multiple values (for example set by non-watermill
+	// producers) are mapped to metadata. By default, such messages are rejected.
+	MultiValueHeaders MultiValueHeaderStrategy
+
+	// MultiValueSeparator is the separator used by MultiValueHeaderJoin (defaults to ",").
+	MultiValueSeparator string
+}
 
 // reserved header for NATSMarshaler to send UUID
 const WatermillUUIDHdr = "_watermill_message_uuid"
 
+// isReservedHeader returns true for headers used by JetStream and the watermill UUID header.
+func isReservedHeader(k string) bool {
+	switch k {
+	case WatermillUUIDHdr, nats.MsgIdHdr, nats.ExpectedLastMsgIdHdr, nats.ExpectedStreamHdr, nats.ExpectedLastSubjSeqHdr, nats.ExpectedLastSeqHdr:
+		return true
+	default:
+		return false
+	}
+}
+
 // Marshal transforms a watermill message into JSON format.
-func (*NATSMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, error) {
+func (m *NATSMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, error) {
 	header := make(nats.Header)
 
 	header.Set(WatermillUUIDHdr, msg.UUID)
 
+	multiValueHeaders := multiValueHeaderNames(msg.Metadata)
+
 	for k, v := range msg.Metadata {
-		header.Set(k, v)
+		if k == MultiValueHeadersMetadataKey {
+			continue
+		}
+
+		if containsString(multiValueHeaders, k) {
+			header[k] = m.headerValues(v)
+		} else {
+			header[k] = []string{v}
+		}
 	}
 
 	data := msg.Payload
@@ -125,7 +215,7 @@ func (*NATSMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, er
 }
 
 // Unmarshal extracts a watermill message from a nats message.
-func (*NATSMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+func (m *NATSMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
 	data := natsMsg.Data
 
 	hdr := natsMsg.Header
@@ -134,17 +224,33 @@ func (*NATSMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
 
 	md := make(message.Metadata)
 
+	var multiValueHeaders []string
+
 	for k, v := range hdr {
-		switch k {
-		case WatermillUUIDHdr, nats.MsgIdHdr, nats.ExpectedLastMsgIdHdr, nats.ExpectedStreamHdr, nats.ExpectedLastSubjSeqHdr, nats.ExpectedLastSeqHdr:
+		if isReservedHeader(k) || k == MultiValueHeadersMetadataKey {
 			continue
-		default:
-			if len(v) == 1 {
-				md.Set(k, v[0])
-			} else {
-				return nil, errors.Errorf("multiple values received in NATS header for %q: (%+v)", k, v)
-			}
 		}
+
+		value, err := m.metadataValue(k, v)
+		if err != nil {
+			return nil, err
+		}
+
+		md.Set(k, value)
+
+		if len(v) > 1 && (m.MultiValueHeaders == MultiValueHeaderJoin || m.MultiValueHeaders == MultiValueHeaderJSON) {
+			multiValueHeaders = append(multiValueHeaders, k)
+		}
+	}
+
+	if len(multiValueHeaders) > 0 {
+		sort.Strings(multiValueHeaders)
+
+		encoded, err := json.Marshal(multiValueHeaders)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot encode multi-value header names")
+		}
+		md.Set(MultiValueHeadersMetadataKey, string(encoded))
 	}
 
 	msg := message.NewMessage(id, data)
@@ -152,3 +258,71 @@ func (*NATSMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
 
 	return msg, nil
 }
+
+func (m *NATSMarshaler) separator() string {
+	if m.MultiValueSeparator == "" {
+		return ","
+	}
+
+	return m.MultiValueSeparator
+}
+
+// metadataValue maps header values to a single metadata value, according to MultiValueHeaders.
+func (m *NATSMarshaler) metadataValue(header string, values []string) (string, error) {
+	if len(values) == 1 {
+		return values[0], nil
+	}
+
+	switch m.MultiValueHeaders {
+	case MultiValueHeaderJoin:
+		return strings.Join(values, m.separator()), nil
+	case MultiValueHeaderFirst:
+		if len(values) == 0 {
+			return "", nil
+		}
+		return values[0], nil
+	case MultiValueHeaderLast:
+		if len(values) == 0 {
+			return "", nil
+		}
+		return values[len(values)-1], nil
+	case MultiValueHeaderJSON:
+		encoded, err := json.Marshal(values)
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot encode values of NATS header %q", header)
+		}
+		return string(encoded), nil
+	default:
+		return "", &MultipleHeaderValuesError{Header: header, Values: values}
+	}
+}
+
+// headerValues maps a metadata value joined or encoded by metadataValue back to header values.
+func (m *NATSMarshaler) headerValues(value string) []string {
+	switch m.MultiValueHeaders {
+	case MultiValueHeaderJoin:
+		return strings.Split(value, m.separator())
+	case MultiValueHeaderJSON:
+		var values []string
+		if json.Unmarshal([]byte(value), &values) == nil {
+			return values
+		}
+	}
+
+	return []string{value}
+}
+
+// multiValueHeaderNames returns the names of headers listed in MultiValueHeadersMetadataKey.
+func multiValueHeaderNames(md message.Metadata) []string {
+	encoded := md.Get(MultiValueHeadersMetadataKey)
+	if encoded == "" {
+		return nil
+	}
+
+	var names []string
+	if err := json.Unmarshal([]byte(encoded), &names); err != nil {
+		return nil
+	}
+
+	return names
+}
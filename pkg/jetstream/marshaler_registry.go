@@ -0,0 +1,169 @@
+package jetstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// MarshalerContentTypeHdr is the header used by MarshalerRegistry to store the content type of a message.
+const MarshalerContentTypeHdr = "_watermill_content_type"
+
+// Content types of the marshalers provided by this package.
+const (
+	GobContentType  = "application/x-watermill-gob"
+	JSONContentType = "application/x-watermill-json"
+	NATSContentType = "application/x-watermill-nats"
+)
+
+// FormatDetector reports whether a message without content type header was (likely) written in a format.
+type FormatDetector func(natsMsg *nats.Msg) bool
+
+type registeredMarshaler struct {
+	contentType string
+	marshaler   MarshalerUnmarshaler
+	detector    FormatDetector
+}
+
+// MarshalerRegistry is a MarshalerUnmarshaler dispatching to registered marshalers based on a content type header.
+//
+// Outgoing messages are marshaled with the marshaler of the default content type and tagged with the
+// MarshalerContentTypeHdr header. Incoming messages are unmarshaled with the marshaler registered for their
+// content type. For messages without the header (written before the registry was introduced), registered
+// marshalers are tried in registration order, skipping those whose FormatDetector rejects the message.
+//
+// It allows to migrate between marshalers without a flag day: subscribers register both marshalers first,
+// then publishers switch the default content type.
+// Register must not be called concurrently with Marshal or Unmarshal.
+type MarshalerRegistry struct {
+	defaultContentType string
+	marshalers         []registeredMarshaler
+}
+
+// NewMarshalerRegistry creates a new, empty MarshalerRegistry, marshaling messages with defaultContentType.
+func NewMarshalerRegistry(defaultContentType string) *MarshalerRegistry {
+	return &MarshalerRegistry{defaultContentType: defaultContentType}
+}
+
+// NewDefaultMarshalerRegistry creates a MarshalerRegistry with NATSMarshaler, JSONMarshaler and GobMarshaler registered.
+func NewDefaultMarshalerRegistry(defaultContentType string) *MarshalerRegistry {
+	return NewMarshalerRegistry(defaultContentType).
+		Register(NATSContentType, &NATSMarshaler{}, DetectNATSFormat).
+		Register(JSONContentType, &JSONMarshaler{}, DetectJSONFormat).
+		Register(GobContentType, &GobMarshaler{}, nil)
+}
+
+// Register registers marshaler for contentType.
+// When detector is nil, the marshaler is tried for all messages without the content type header.
+func (r *MarshalerRegistry) Register(contentType string, marshaler MarshalerUnmarshaler, detector FormatDetector) *MarshalerRegistry {
+	for i, registered := range r.marshalers {
+		if registered.contentType == contentType {
+			r.marshalers[i] = registeredMarshaler{contentType, marshaler, detector}
+			return r
+		}
+	}
+
+	r.marshalers = append(r.marshalers, registeredMarshaler{contentType, marshaler, detector})
+
+	return r
+}
+
+// Marshal marshals msg with the marshaler of the default content type and tags it with the content type.
+func (r *MarshalerRegistry) Marshal(topic string, msg *message.Message) (*nats.Msg, error) {
+	registered, ok := r.find(r.defaultContentType)
+	if !ok {
+		return nil, errors.Errorf("no marshaler registered for default content type %q", r.defaultContentType)
+	}
+
+	natsMsg, err := registered.marshaler.Marshal(topic, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if natsMsg.Header == nil {
+		natsMsg.Header = make(nats.Header)
+	}
+
+	natsMsg.Header.Set(MarshalerContentTypeHdr, registered.contentType)
+
+	return natsMsg, nil
+}
+
+// Unmarshal unmarshals natsMsg with the marshaler registered for its content type,
+// or detects the format when the content type header is missing.
+func (r *MarshalerRegistry) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	contentType := natsMsg.Header.Get(MarshalerContentTypeHdr)
+
+	if contentType != "" {
+		registered, ok := r.find(contentType)
+		if !ok {
+			return nil, errors.Errorf("no marshaler registered for content type %q", contentType)
+		}
+
+		return registered.marshaler.Unmarshal(withData(natsMsg, natsMsg.Data, MarshalerContentTypeHdr))
+	}
+
+	var lastErr error
+
+	for _, registered := range r.marshalers {
+		if registered.detector != nil && !registered.detector(natsMsg) {
+			continue
+		}
+
+		msg, err := registered.marshaler.Unmarshal(natsMsg)
+		if err == nil {
+			return msg, nil
+		}
+
+		lastErr = errors.Wrapf(err, "cannot unmarshal as %s", registered.contentType)
+	}
+
+	if lastErr == nil {
+		return nil, errors.New("cannot detect message format")
+	}
+
+	return nil, lastErr
+}
+
+func (r *MarshalerRegistry) find(contentType string) (registeredMarshaler, bool) {
+	for _, registered := range r.marshalers {
+		if registered.contentType == contentType {
+			return registered, true
+		}
+	}
+
+	return registeredMarshaler{}, false
+}
+
+// DetectNATSFormat detects messages written by NATSMarshaler.
+func DetectNATSFormat(natsMsg *nats.Msg) bool {
+	return natsMsg.Header.Get(WatermillUUIDHdr) != ""
+}
+
+// DetectJSONFormat detects messages written by JSONMarshaler.
+func DetectJSONFormat(natsMsg *nats.Msg) bool {
+	data := bytes.TrimSpace(natsMsg.Data)
+	if len(data) == 0 || data[0] != '{' {
+		return false
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return false
+	}
+
+	_, hasUUID := fields["UUID"]
+	_, hasPayload := fields["Payload"]
+
+	return hasUUID && hasPayload
+}
+
+// DetectCloudEventsFormat detects CloudEvents in binary or structured mode.
+func DetectCloudEventsFormat(natsMsg *nats.Msg) bool {
+	return natsMsg.Header.Get(CloudEventsHeaderPrefix+"specversion") != "" ||
+		strings.HasPrefix(natsMsg.Header.Get(ContentTypeHdr), "application/cloudevents")
+}
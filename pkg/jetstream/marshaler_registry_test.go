@@ -0,0 +1,68 @@
+package jetstream_test
+
+import (
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream/wmpb"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalerRegistry_Migration(t *testing.T) {
+	legacyMsg := sampleMessage(100)
+	legacyNatsMsg, err := (&jetstream.GobMarshaler{}).Marshal("topic", legacyMsg)
+	require.NoError(t, err)
+
+	legacyJSONMsg := sampleMessage(100)
+	legacyJSONNatsMsg, err := (&jetstream.JSONMarshaler{}).Marshal("topic", legacyJSONMsg)
+	require.NoError(t, err)
+
+	// publishers still using gob, but tagging messages
+	gobRegistry := jetstream.NewDefaultMarshalerRegistry(jetstream.GobContentType)
+	taggedGobMsg := sampleMessage(100)
+	taggedGobNatsMsg, err := gobRegistry.Marshal("topic", taggedGobMsg)
+	require.NoError(t, err)
+	assert.Equal(t, jetstream.GobContentType, taggedGobNatsMsg.Header.Get(jetstream.MarshalerContentTypeHdr))
+
+	// publishers switched to NATSMarshaler
+	registry := jetstream.NewDefaultMarshalerRegistry(jetstream.NATSContentType).
+		Register(wmpb.ContentType, &wmpb.NATSMarshaler{}, nil)
+	newMsg := sampleMessage(100)
+	newNatsMsg, err := registry.Marshal("topic", newMsg)
+	require.NoError(t, err)
+	assert.Equal(t, jetstream.NATSContentType, newNatsMsg.Header.Get(jetstream.MarshalerContentTypeHdr))
+
+	for name, tc := range map[string]struct {
+		msg     *message.Message
+		natsMsg *nats.Msg
+	}{
+		"legacy gob":  {legacyMsg, legacyNatsMsg},
+		"legacy json": {legacyJSONMsg, legacyJSONNatsMsg},
+		"tagged gob":  {taggedGobMsg, taggedGobNatsMsg},
+		"tagged nats": {newMsg, newNatsMsg},
+	} {
+		t.Run(name, func(t *testing.T) {
+			unmarshaledMsg, err := registry.Unmarshal(tc.natsMsg)
+			require.NoError(t, err)
+			assert.True(t, tc.msg.Equals(unmarshaledMsg))
+		})
+	}
+}
+
+func TestMarshalerRegistry_Errors(t *testing.T) {
+	registry := jetstream.NewMarshalerRegistry("unknown")
+
+	_, err := registry.Marshal("topic", sampleMessage(10))
+	require.Error(t, err)
+
+	natsMsg := nats.NewMsg("topic")
+	natsMsg.Header.Set(jetstream.MarshalerContentTypeHdr, "unknown")
+	_, err = registry.Unmarshal(natsMsg)
+	require.Error(t, err)
+
+	_, err = jetstream.NewDefaultMarshalerRegistry(jetstream.NATSContentType).Unmarshal(nats.NewMsg("topic"))
+	require.Error(t, err)
+}
@@ -0,0 +1,40 @@
+package jetstream
+
+import (
+	"context"
+	"time"
+)
+
+// Metrics is used to instrument the publish and consume paths.
+//
+// Metrics are optional, when no implementation is provided nothing is recorded.
+// All methods must be safe for concurrent use.
+// See the wmotel package for an OpenTelemetry implementation.
+type Metrics interface {
+	// MessagePublished is called after a message publication finished, err is nil when it succeeded.
+	MessagePublished(ctx context.Context, topic string, duration time.Duration, err error)
+
+	// MessageReceived is called when a message was received from JetStream.
+	MessageReceived(ctx context.Context, topic string)
+
+	// MessageUnmarshalFailed is called when a received message could not be unmarshaled.
+	MessageUnmarshalFailed(ctx context.Context, topic string)
+
+	// MessageAcked is called when a message was acknowledged.
+	MessageAcked(ctx context.Context, topic string)
+
+	// MessageNacked is called when a message was negatively acknowledged with the given delay.
+	MessageNacked(ctx context.Context, topic string, delay time.Duration)
+
+	// MessageTermed is called when a message was terminated and will not be redelivered.
+	MessageTermed(ctx context.Context, topic string)
+
+	// MessageAckTimedOut is called when the handler did not ack or nack a message within AckWaitTimeout.
+	MessageAckTimedOut(ctx context.Context, topic string)
+
+	// InFlightChanged is called when the number of messages being processed changes by delta.
+	InFlightChanged(ctx context.Context, topic string, delta int64)
+
+	// ConsumerLag is called with the number of messages pending for the consumer, as reported by the last received message.
+	ConsumerLag(ctx context.Context, topic string, pending uint64)
+}
@@ -0,0 +1,81 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	_, js, _ := natsTestSetup(t)
+
+	topic := "metrics_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	metrics := newCountingMetrics()
+
+	pub := newTestPublisher(t, func(config *jetstream.PublisherConfig) {
+		config.Metrics = metrics
+	})
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsTestURL(),
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		Metrics:       metrics,
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	published := []*message.Message{
+		message.NewMessage(watermill.NewUUID(), nil),
+		message.NewMessage(watermill.NewUUID(), nil),
+		message.NewMessage(watermill.NewUUID(), nil),
+	}
+	require.NoError(t, pub.Publish(topic, published...))
+
+	nacked := published[1].UUID
+	acked := map[string]struct{}{}
+
+	for len(acked) < len(published) {
+		select {
+		case msg := <-messages:
+			// the message is in flight until it is acked or nacked
+			assert.Equal(t, 1, metrics.count("in_flight"))
+
+			if msg.UUID == nacked && metrics.count("nacked") == 0 {
+				msg.Nack()
+				continue
+			}
+
+			msg.Ack()
+			acked[msg.UUID] = struct{}{}
+		case <-ctx.Done():
+			t.Fatalf("only %d messages acked", len(acked))
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		return metrics.count("acked") == 3 && metrics.count("in_flight") == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 3, metrics.count("published"))
+	assert.Equal(t, 4, metrics.count("received"))
+	assert.Equal(t, 1, metrics.count("nacked"))
+	assert.Equal(t, 0, metrics.count("termed"))
+	assert.Equal(t, 0, metrics.count("unmarshal_failed"))
+}
@@ -0,0 +1,248 @@
+package jetstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pkg/errors"
+)
+
+// OutboxPartitionKeyFunc returns the partition key of a message read from the outbox.
+// Messages with the same key are forwarded in order, messages with different keys can be forwarded concurrently.
+type OutboxPartitionKeyFunc func(msg *message.Message) string
+
+// OutboxMetadataPartitionKey uses the metadata value with the given key as the partition key.
+func OutboxMetadataPartitionKey(key string) OutboxPartitionKeyFunc {
+	return func(msg *message.Message) string {
+		return msg.Metadata.Get(key)
+	}
+}
+
+// OutboxConfig is the configuration to create an outbox forwarder.
+type OutboxConfig struct {
+	// SourceTopic is the topic of the outbox subscribed from the source subscriber.
+	SourceTopic string
+
+	// TargetTopic is the topic messages are published to (defaults to SourceTopic).
+	TargetTopic string
+
+	// TargetTopicFunc returns the topic a message is published to, it takes precedence over TargetTopic.
+	TargetTopicFunc func(msg *message.Message) string
+
+	// Workers is the number of messages forwarded concurrently (defaults to 1).
+	// Concurrency is limited by the source: subscribers delivering the next message only after
+	// the previous one is acked, like SQL subscribers, are forwarded one message at a time.
+	Workers int
+
+	// PartitionKey routes messages to workers, messages with the same key are forwarded in order.
+	// It is required when Workers is greater than 1.
+	PartitionKey OutboxPartitionKeyFunc
+
+	// RetryDelay is the delay before publishing is retried after a failure (defaults to 1s).
+	// Failed messages are retried by the same worker, so messages with the same key are not reordered.
+	RetryDelay time.Duration
+}
+
+func (c *OutboxConfig) setDefaults() {
+	if c.TargetTopic == "" {
+		c.TargetTopic = c.SourceTopic
+	}
+	if c.Workers <= 0 {
+		c.Workers = 1
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = time.Second
+	}
+}
+
+// Validate ensures configuration is valid before use
+func (c OutboxConfig) Validate() error {
+	if c.SourceTopic == "" {
+		return errors.New("OutboxConfig.SourceTopic is missing")
+	}
+
+	if c.Workers > 1 && c.PartitionKey == nil {
+		return errors.New("OutboxConfig.PartitionKey is required when Workers is greater than 1")
+	}
+
+	return nil
+}
+
+func (c OutboxConfig) targetTopic(msg *message.Message) string {
+	if c.TargetTopicFunc != nil {
+		return c.TargetTopicFunc(msg)
+	}
+
+	return c.TargetTopic
+}
+
+// Outbox forwards messages from a transactional outbox, read with any watermill subscriber (for example SQL),
+// to JetStream.
+//
+// A source message is acked only after JetStream acknowledged the published message, so no message is lost.
+// Messages are published with their UUID as the message ID, so messages forwarded again after a crash
+// are deduplicated by JetStream within the duplicate window of the stream.
+type Outbox struct {
+	subscriber message.Subscriber
+	publisher  *Publisher
+	config     OutboxConfig
+	logger     watermill.LoggerAdapter
+
+	runningLock sync.Mutex
+	running     bool
+}
+
+// NewOutbox creates a new Outbox forwarding messages from subscriber with publisher.
+// TrackMsgId is always enabled for messages forwarded by the outbox.
+func NewOutbox(
+	subscriber message.Subscriber,
+	publisher *Publisher,
+	config OutboxConfig,
+	logger watermill.LoggerAdapter,
+) (*Outbox, error) {
+	config.setDefaults()
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	if subscriber == nil {
+		return nil, errors.New("outbox subscriber is missing")
+	}
+	if publisher == nil {
+		return nil, errors.New("outbox publisher is missing")
+	}
+
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+
+	// the forwarder shares the connection of publisher, it is closed with publisher
+	forwarderConfig := publisher.config
+	forwarderConfig.TrackMsgId = true
+
+	forwarder, err := NewPublisherWithNatsConn(publisher.conn, forwarderConfig, publisher.logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create outbox publisher")
+	}
+
+	return &Outbox{
+		subscriber: subscriber,
+		publisher:  forwarder,
+		config:     config,
+		logger:     logger,
+	}, nil
+}
+
+// Run forwards messages until ctx is cancelled or the source subscription is closed.
+func (o *Outbox) Run(ctx context.Context) error {
+	o.runningLock.Lock()
+	if o.running {
+		o.runningLock.Unlock()
+		return errors.New("outbox is already running")
+	}
+	o.running = true
+	o.runningLock.Unlock()
+
+	defer func() {
+		o.runningLock.Lock()
+		o.running = false
+		o.runningLock.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	messages, err := o.subscriber.Subscribe(ctx, o.config.SourceTopic)
+	if err != nil {
+		return errors.Wrapf(err, "cannot subscribe to outbox topic %s", o.config.SourceTopic)
+	}
+
+	queues := make([]chan *message.Message, o.config.Workers)
+	wg := sync.WaitGroup{}
+
+	for i := range queues {
+		queue := make(chan *message.Message)
+		queues[i] = queue
+
+		logFields := watermill.LogFields{
+			"source_topic": o.config.SourceTopic,
+			"worker_num":   i,
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for msg := range queue {
+				o.forward(ctx, msg, logFields)
+			}
+		}()
+	}
+
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+
+			queue := queues[0]
+			if len(queues) > 1 {
+				queue = queues[partition(o.config.PartitionKey(msg), len(queues))]
+			}
+
+			select {
+			case queue <- msg:
+			case <-ctx.Done():
+				msg.Nack()
+				return nil
+			}
+		}
+	}
+}
+
+// forward publishes msg until it succeeds and acks it, msg is nacked when ctx is cancelled before.
+func (o *Outbox) forward(ctx context.Context, msg *message.Message, logFields watermill.LogFields) {
+	topic := o.config.targetTopic(msg)
+
+	logFields = logFields.Add(watermill.LogFields{
+		"message_uuid": msg.UUID,
+		"topic":        topic,
+	})
+
+	for {
+		if ctx.Err() != nil {
+			msg.Nack()
+			return
+		}
+
+		err := o.publisher.Publish(topic, msg)
+		if err == nil {
+			break
+		}
+
+		o.logger.Error("Cannot forward outbox message", err, logFields)
+
+		select {
+		case <-time.After(o.config.RetryDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	msg.Ack()
+
+	o.logger.Trace("Outbox message forwarded", logFields)
+}
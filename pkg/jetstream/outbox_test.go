@@ -0,0 +1,129 @@
+package jetstream_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutbox(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	topic := "outbox_" + watermill.NewShortUUID()
+	sourceTopic := "outbox_source_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+		_ = js.DeleteStream(sourceTopic)
+	}()
+
+	logger := watermill.NewStdLogger(false, false)
+
+	// the source delivers messages in order, like a SQL outbox
+	sourceSub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsURL,
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+	}, logger)
+	require.NoError(t, err)
+	defer sourceSub.Close()
+
+	outbox, err := jetstream.NewOutbox(sourceSub, pub, jetstream.OutboxConfig{
+		SourceTopic:  sourceTopic,
+		TargetTopic:  topic,
+		Workers:      3,
+		PartitionKey: jetstream.OutboxMetadataPartitionKey("key"),
+	}, logger)
+	require.NoError(t, err)
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsURL,
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+	}, logger)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	var published []*message.Message
+	for i := 0; i < 30; i++ {
+		msg := message.NewMessage(watermill.NewUUID(), []byte(fmt.Sprint(i)))
+		msg.Metadata.Set("key", fmt.Sprint(i%3))
+		published = append(published, msg)
+	}
+
+	// the first message is forwarded again, as after a crash before the source ack
+	duplicate := message.NewMessage(published[0].UUID, published[0].Payload)
+	duplicate.Metadata.Set("key", "0")
+
+	require.NoError(t, pub.Publish(sourceTopic, published...))
+	require.NoError(t, pub.Publish(sourceTopic, duplicate))
+
+	outboxCtx, cancelOutbox := context.WithCancel(ctx)
+	outboxDone := make(chan error)
+	go func() {
+		outboxDone <- outbox.Run(outboxCtx)
+	}()
+
+	received := map[string][]string{}
+	for range published {
+		select {
+		case msg := <-messages:
+			key := msg.Metadata.Get("key")
+			received[key] = append(received[key], msg.UUID)
+			msg.Ack()
+		case <-ctx.Done():
+			t.Fatal("message not forwarded")
+		}
+	}
+
+	requireNoMessage(t, messages)
+
+	for key, uuids := range received {
+		var expected []string
+		for _, msg := range published {
+			if msg.Metadata.Get("key") == key {
+				expected = append(expected, msg.UUID)
+			}
+		}
+		assert.Equal(t, expected, uuids, "messages of key %s should be forwarded in order", key)
+	}
+
+	info, err := js.StreamInfo(topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(published), info.State.Msgs, "forwarded duplicate should be deduplicated")
+
+	cancelOutbox()
+	require.NoError(t, <-outboxDone)
+}
+
+func TestNewOutbox_Validation(t *testing.T) {
+	_, _, pub := natsTestSetup(t)
+
+	outboxPubSub := gochannel.NewGoChannel(gochannel.Config{}, nil)
+	defer outboxPubSub.Close()
+
+	_, err := jetstream.NewOutbox(outboxPubSub, pub, jetstream.OutboxConfig{}, nil)
+	require.Error(t, err)
+
+	_, err = jetstream.NewOutbox(outboxPubSub, pub, jetstream.OutboxConfig{
+		SourceTopic: "outbox",
+		Workers:     2,
+	}, nil)
+	require.Error(t, err)
+
+	_, err = jetstream.NewOutbox(nil, pub, jetstream.OutboxConfig{SourceTopic: "outbox"}, nil)
+	require.Error(t, err)
+}
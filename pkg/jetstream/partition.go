@@ -0,0 +1,107 @@
+package jetstream
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+)
+
+// PartitionKeyFunc returns the partition key of a received message.
+// Messages with the same key are processed in order, messages with different keys can be processed concurrently.
+type PartitionKeyFunc func(natsMsg *nats.Msg) string
+
+// SubjectTokenPartitionKey uses the subject token at index (starting from 0) as the partition key,
+// for example index 1 of "orders.customer-1.created" is "customer-1".
+// Messages with fewer tokens have an empty key.
+func SubjectTokenPartitionKey(index int) PartitionKeyFunc {
+	return func(natsMsg *nats.Msg) string {
+		tokens := strings.Split(natsMsg.Subject, ".")
+		if index < 0 || index >= len(tokens) {
+			return ""
+		}
+
+		return tokens[index]
+	}
+}
+
+// MetadataPartitionKey uses the metadata value with the given key as the partition key.
+//
+// The key is read from NATS headers before unmarshaling, so it works with marshalers
+// storing metadata in headers, like NATSMarshaler.
+func MetadataPartitionKey(key string) PartitionKeyFunc {
+	return func(natsMsg *nats.Msg) string {
+		return natsMsg.Header.Get(key)
+	}
+}
+
+// partitionQueueSize is the number of messages buffered per worker before the subscription blocks.
+const partitionQueueSize = 64
+
+// startPartitionWorkers starts the configured number of workers processing messages of a single subscription
+// and returns the handler routing messages to them by partition key.
+//
+// Workers are tracked by wg, so output is not closed before they stop.
+// A message holds its inFlight slot from routing until it is processed.
+func (s *Subscriber) startPartitionWorkers(
+	ctx context.Context,
+	config *SubscriberSubscriptionConfig,
+	topic string,
+	output chan *message.Message,
+	logFields watermill.LogFields,
+	wg *sync.WaitGroup,
+	inFlight inFlightLimiter,
+) nats.MsgHandler {
+	queues := make([]chan *nats.Msg, config.Workers)
+
+	for i := range queues {
+		queue := make(chan *nats.Msg, partitionQueueSize)
+		queues[i] = queue
+
+		workerLogFields := logFields.Add(watermill.LogFields{"worker_num": i})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-s.closing:
+					return
+				case <-ctx.Done():
+					return
+				case msg := <-queue:
+					s.processMessage(ctx, config, topic, msg, output, workerLogFields)
+					inFlight.release()
+				}
+			}
+		}()
+	}
+
+	return func(msg *nats.Msg) {
+		if !inFlight.acquire(ctx, s.closing) {
+			return
+		}
+
+		queue := queues[partition(config.PartitionKey(msg), len(queues))]
+
+		select {
+		case queue <- msg:
+		case <-s.closing:
+			inFlight.release()
+		case <-ctx.Done():
+			inFlight.release()
+		}
+	}
+}
+
+func partition(key string, partitions int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return int(h.Sum32() % uint32(partitions))
+}
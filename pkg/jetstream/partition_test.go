@@ -0,0 +1,116 @@
+package jetstream_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriber_Partitioned_Workers(t *testing.T) {
+	_, js := natsTestConn(t)
+
+	topic := "partitioned_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	logger := watermill.NewStdLogger(false, false)
+
+	pub := newTestPublisher(t, nil)
+
+	const (
+		customers           = 8
+		messagesPerCustomer = 20
+	)
+
+	for i := 0; i < messagesPerCustomer; i++ {
+		for c := 0; c < customers; c++ {
+			msg := message.NewMessage(watermill.NewUUID(), []byte(strconv.Itoa(i)))
+			msg.Metadata.Set("customer", fmt.Sprintf("customer-%d", c))
+			require.NoError(t, pub.Publish(topic, msg))
+		}
+	}
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsTestURL(),
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		Workers:       4,
+		PartitionKey:  jetstream.MetadataPartitionKey("customer"),
+	}, logger)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	var (
+		lock     sync.Mutex
+		received = map[string][]int{}
+
+		inFlight    int32
+		maxInFlight int32
+	)
+
+	for i := 0; i < customers*messagesPerCustomer; i++ {
+		var msg *message.Message
+		select {
+		case msg = <-messages:
+		case <-ctx.Done():
+			t.Fatal("messages not received")
+		}
+
+		go func(msg *message.Message) {
+			current := atomic.AddInt32(&inFlight, 1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+					break
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+
+			seq, err := strconv.Atoi(string(msg.Payload))
+			assert.NoError(t, err)
+
+			lock.Lock()
+			customer := msg.Metadata.Get("customer")
+			received[customer] = append(received[customer], seq)
+			lock.Unlock()
+
+			atomic.AddInt32(&inFlight, -1)
+			msg.Ack()
+		}(msg)
+	}
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&inFlight) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Len(t, received, customers)
+	for customer, seqs := range received {
+		require.Len(t, seqs, messagesPerCustomer, customer)
+		for i, seq := range seqs {
+			assert.Equal(t, i, seq, "messages of %s processed out of order", customer)
+		}
+	}
+
+	assert.Greater(t, atomic.LoadInt32(&maxInFlight), int32(1), "messages with different keys should be processed concurrently")
+}
@@ -0,0 +1,284 @@
+package jetstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrTopicNotSubscribed is returned by Pause and Resume when the subscriber has no subscription of the topic.
+	ErrTopicNotSubscribed = errors.New("topic is not subscribed")
+
+	// ErrPauseRequiresDurable is returned by Pause when neither DurableName nor QueueGroup is set,
+	// ephemeral consumers are removed by the server while no one is subscribed.
+	ErrPauseRequiresDurable = errors.New("pausing requires DurableName or QueueGroup")
+)
+
+// CircuitBreaker reports whether consuming topic should be paused, for example while a downstream service is unavailable.
+// It is called before every message is processed, so it should be cheap.
+type CircuitBreaker func(topic string) bool
+
+// topicSubscription keeps track of the nats subscriptions of a single Subscribe call, so they can be paused.
+type topicSubscription struct {
+	ctx      context.Context
+	topic    string
+	config   *SubscriberSubscriptionConfig
+	handlers []nats.MsgHandler
+
+	lock            sync.Mutex
+	subs            []*nats.Subscription
+	paused          bool
+	pausedByBreaker bool
+	closed          bool
+}
+
+func (t *topicSubscription) isPaused() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.paused
+}
+
+// isStopped returns true when messages should not be processed anymore, because ts is paused or closed.
+func (t *topicSubscription) isStopped() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.paused || t.closed
+}
+
+// Pause stops consuming topic until Resume is called.
+//
+// Push subscriptions are removed while their durable consumer is kept, messages which are already
+// being processed complete, messages received after pausing are nacked and redelivered after resuming.
+func (s *Subscriber) Pause(topic string) error {
+	subs := s.topicSubscriptions(topic)
+	if len(subs) == 0 {
+		return ErrTopicNotSubscribed
+	}
+
+	for _, ts := range subs {
+		if ts.config.ephemeralConsumer(ts.topic) {
+			return ErrPauseRequiresDurable
+		}
+	}
+
+	for _, ts := range subs {
+		if err := s.pause(ts, false); err != nil {
+			return errors.Wrapf(err, "cannot pause topic %s", topic)
+		}
+	}
+
+	return nil
+}
+
+// Resume resumes consuming topic paused by Pause or by the circuit breaker.
+func (s *Subscriber) Resume(topic string) error {
+	subs := s.topicSubscriptions(topic)
+	if len(subs) == 0 {
+		return ErrTopicNotSubscribed
+	}
+
+	for _, ts := range subs {
+		if err := s.resume(ts); err != nil {
+			return errors.Wrapf(err, "cannot resume topic %s", topic)
+		}
+	}
+
+	return nil
+}
+
+func (s *Subscriber) pause(ts *topicSubscription, byBreaker bool) error {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	if ts.closed {
+		return nil
+	}
+	if ts.paused {
+		// pausing explicitly prevents resuming when the circuit breaker recovers
+		ts.pausedByBreaker = ts.pausedByBreaker && byBreaker
+		return nil
+	}
+
+	var err error
+	for _, sub := range ts.subs {
+		s.removeActiveSubscription(sub)
+
+		if detachErr := s.detach(sub); detachErr != nil && err == nil {
+			err = detachErr
+		}
+	}
+
+	ts.subs = nil
+	ts.paused = true
+	ts.pausedByBreaker = byBreaker
+
+	s.logger.Info("Topic paused", watermill.LogFields{"topic": ts.topic, "circuit_breaker": byBreaker})
+
+	if byBreaker {
+		go s.watchCircuitBreaker(ts)
+	}
+
+	return err
+}
+
+func (s *Subscriber) resume(ts *topicSubscription) error {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	if ts.closed || !ts.paused {
+		return nil
+	}
+
+	if err := s.subscribeAll(ts); err != nil {
+		return err
+	}
+
+	ts.paused = false
+	ts.pausedByBreaker = false
+
+	s.logger.Info("Topic resumed", watermill.LogFields{"topic": ts.topic})
+
+	return nil
+}
+
+// pausable wraps handler, so messages received while paused or after closing are nacked
+// and the circuit breaker is checked.
+func (s *Subscriber) pausable(ts *topicSubscription, handler nats.MsgHandler, logFields watermill.LogFields) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		if ts.config.CircuitBreaker != nil && !ts.isPaused() && ts.config.CircuitBreaker(ts.topic) {
+			if err := s.pause(ts, true); err != nil {
+				s.logger.Error("Cannot pause topic", err, logFields)
+			}
+		}
+
+		if ts.isStopped() {
+			if err := msg.Nak(); err != nil {
+				s.logger.Error("Cannot send nak", err, logFields)
+			}
+			return
+		}
+
+		handler(msg)
+	}
+}
+
+// watchCircuitBreaker resumes ts once the circuit breaker recovers, unless it was paused explicitly.
+func (s *Subscriber) watchCircuitBreaker(ts *topicSubscription) {
+	ticker := time.NewTicker(ts.config.CircuitBreakerInterval)
+	defer ticker.Stop()
+
+	logFields := watermill.LogFields{"topic": ts.topic}
+
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ts.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ts.lock.Lock()
+		pausedByBreaker := ts.paused && ts.pausedByBreaker
+		ts.lock.Unlock()
+
+		if !pausedByBreaker {
+			return
+		}
+
+		if ts.config.CircuitBreaker(ts.topic) {
+			continue
+		}
+
+		if err := s.resume(ts); err != nil {
+			s.logger.Error("Cannot resume topic after circuit breaker recovered", err, logFields)
+			continue
+		}
+
+		return
+	}
+}
+
+// subscribeAll creates a nats subscription for every handler of ts, ts must be locked.
+func (s *Subscriber) subscribeAll(ts *topicSubscription) error {
+	subs := make([]*nats.Subscription, 0, len(ts.handlers))
+
+	for _, handler := range ts.handlers {
+		sub, err := s.subscribe(ts.config, ts.topic, handler)
+		if err == nil {
+			err = ts.config.setPendingLimits(sub)
+		}
+		if err != nil {
+			for _, sub := range subs {
+				s.removeActiveSubscription(sub)
+				_ = sub.Unsubscribe()
+			}
+			return err
+		}
+
+		s.addActiveSubscription(ts.topic, sub)
+		subs = append(subs, sub)
+	}
+
+	ts.subs = subs
+
+	return nil
+}
+
+// unsubscribeAll removes the subscriptions of ts, when the Subscribe context is done or the subscriber is closed.
+func (s *Subscriber) unsubscribeAll(ts *topicSubscription) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	ts.closed = true
+
+	for _, sub := range ts.subs {
+		s.removeActiveSubscription(sub)
+
+		if err := s.closeSubscription(ts.config, ts.topic, sub); err != nil {
+			s.logger.Error("Cannot unsubscribe", err, watermill.LogFields{"topic": ts.topic})
+		}
+	}
+
+	ts.subs = nil
+}
+
+func (s *Subscriber) addTopicSubscription(ts *topicSubscription) {
+	s.topicSubsLock.Lock()
+	defer s.topicSubsLock.Unlock()
+
+	s.topicSubs[ts.topic] = append(s.topicSubs[ts.topic], ts)
+}
+
+func (s *Subscriber) removeTopicSubscription(ts *topicSubscription) {
+	s.topicSubsLock.Lock()
+	defer s.topicSubsLock.Unlock()
+
+	subs := s.topicSubs[ts.topic]
+	for i, other := range subs {
+		if other == ts {
+			subs = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+
+	if len(subs) == 0 {
+		delete(s.topicSubs, ts.topic)
+	} else {
+		s.topicSubs[ts.topic] = subs
+	}
+}
+
+func (s *Subscriber) topicSubscriptions(topic string) []*topicSubscription {
+	s.topicSubsLock.Lock()
+	defer s.topicSubsLock.Unlock()
+
+	return append([]*topicSubscription(nil), s.topicSubs[topic]...)
+}
@@ -0,0 +1,198 @@
+package jetstream_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriber_Pause_Resume(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	topic := "pause_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	durableName := "durable_" + watermill.NewShortUUID()
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsURL,
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		DurableName:   durableName,
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	require.ErrorIs(t, sub.Pause(topic), jetstream.ErrTopicNotSubscribed)
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	beforePause := message.NewMessage(watermill.NewUUID(), nil)
+	require.NoError(t, pub.Publish(topic, beforePause))
+	requireMessage(t, ctx, messages, beforePause)
+
+	require.NoError(t, sub.Pause(topic))
+
+	_, err = js.ConsumerInfo(topic, durableName)
+	require.NoError(t, err, "durable consumer should be kept while paused")
+
+	whilePaused := message.NewMessage(watermill.NewUUID(), nil)
+	require.NoError(t, pub.Publish(topic, whilePaused))
+	requireNoMessage(t, messages)
+
+	require.NoError(t, sub.Resume(topic))
+	requireMessage(t, ctx, messages, whilePaused)
+}
+
+func TestSubscriber_Pause_Before_First_Message(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	topic := "pause_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	durableName := "durable_" + watermill.NewShortUUID()
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsURL,
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		DurableName:   durableName,
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	// the consumer was created by this subscription and nothing was delivered yet
+	require.NoError(t, sub.Pause(topic))
+
+	_, err = js.ConsumerInfo(topic, durableName)
+	require.NoError(t, err, "durable consumer should be kept while paused")
+
+	whilePaused := message.NewMessage(watermill.NewUUID(), nil)
+	require.NoError(t, pub.Publish(topic, whilePaused))
+	requireNoMessage(t, messages)
+
+	require.NoError(t, sub.Resume(topic))
+	requireMessage(t, ctx, messages, whilePaused)
+}
+
+func TestSubscriber_Pause_Requires_Durable(t *testing.T) {
+	natsURL, js, _ := natsTestSetup(t)
+
+	topic := "pause_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsURL,
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+	}, nil)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	_, err = sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, sub.Pause(topic), jetstream.ErrPauseRequiresDurable)
+
+	_, err = sub.SubscribeWithConfig(context.Background(), topic, func(topic string, config *jetstream.SubscriberSubscriptionConfig) {
+		config.CircuitBreaker = func(string) bool { return false }
+	})
+	require.Error(t, err, "circuit breaker requires a durable consumer")
+}
+
+func TestSubscriber_Pause_Queue_Group(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	topic := "pause_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:            natsURL,
+		Unmarshaler:    &jetstream.NATSMarshaler{},
+		AutoProvision:  true,
+		QueueGroup:     "grp",
+		CircuitBreaker: func(string) bool { return false },
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	// the queue group is the durable name of the shared consumer
+	require.NoError(t, sub.Pause(topic))
+
+	_, err = js.ConsumerInfo(topic, "grp")
+	require.NoError(t, err, "queue group consumer should be kept while paused")
+
+	whilePaused := message.NewMessage(watermill.NewUUID(), nil)
+	require.NoError(t, pub.Publish(topic, whilePaused))
+	requireNoMessage(t, messages)
+
+	require.NoError(t, sub.Resume(topic))
+	requireMessage(t, ctx, messages, whilePaused)
+}
+
+func TestSubscriber_Circuit_Breaker(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	topic := "circuit_breaker_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	var tripped int32 = 1
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsURL,
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		DurableName:   "durable_" + watermill.NewShortUUID(),
+		CircuitBreaker: func(string) bool {
+			return atomic.LoadInt32(&tripped) == 1
+		},
+		CircuitBreakerInterval: 100 * time.Millisecond,
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+	require.NoError(t, pub.Publish(topic, msg))
+	requireNoMessage(t, messages)
+
+	atomic.StoreInt32(&tripped, 0)
+	requireMessage(t, ctx, messages, msg)
+}
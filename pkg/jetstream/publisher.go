@@ -1,6 +1,9 @@
 package jetstream
 
 import (
+	"context"
+	"time"
+
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/nats-io/nats.go"
@@ -32,6 +35,22 @@ type PublisherConfig struct {
 
 	// TrackMsgId uses the Nats.MsgId option with the msg UUID to prevent duplication
 	TrackMsgId bool
+
+	// Metrics is used to record publish latency and errors. Nothing is recorded when it is nil.
+	Metrics Metrics
+
+	// Tracer is used to propagate trace context in NATS headers and to create producer spans.
+	// Nothing is traced when it is nil.
+	Tracer Tracer
+
+	// ClaimCheck enables storing the data of large messages in an Object Store bucket.
+	// Messages are published as they are when it is nil.
+	ClaimCheck *ClaimCheckConfig
+
+	// Scheduling enables delayed delivery of messages with DeliverAtMetadataKey metadata.
+	// Such messages are stored in the scheduling stream and released by Scheduler once due.
+	// When it is nil, all messages are published immediately.
+	Scheduling *SchedulingConfig
 }
 
 // PublisherPublishConfig is the configuration subset needed for an individual publish call
@@ -53,6 +72,22 @@ type PublisherPublishConfig struct {
 
 	// TrackMsgId uses the Nats.MsgId option with the msg UUID to prevent duplication
 	TrackMsgId bool
+
+	// Metrics is used to record publish latency and errors. Nothing is recorded when it is nil.
+	Metrics Metrics
+
+	// Tracer is used to propagate trace context in NATS headers and to create producer spans.
+	// Nothing is traced when it is nil.
+	Tracer Tracer
+
+	// ClaimCheck enables storing the data of large messages in an Object Store bucket.
+	// Messages are published as they are when it is nil.
+	ClaimCheck *ClaimCheckConfig
+
+	// Scheduling enables delayed delivery of messages with DeliverAtMetadataKey metadata.
+	// Such messages are stored in the scheduling stream and released by Scheduler once due.
+	// When it is nil, all messages are published immediately.
+	Scheduling *SchedulingConfig
 }
 
 func (c *PublisherConfig) setDefaults() {
@@ -82,6 +117,10 @@ func (c PublisherConfig) GetPublisherPublishConfig() PublisherPublishConfig {
 		JetstreamOptions:  c.JetstreamOptions,
 		PublishOptions:    c.PublishOptions,
 		TrackMsgId:        c.TrackMsgId,
+		Metrics:           c.Metrics,
+		Tracer:            c.Tracer,
+		ClaimCheck:        c.ClaimCheck,
+		Scheduling:        c.Scheduling,
 	}
 }
 
@@ -90,8 +129,9 @@ type Publisher struct {
 	conn             *nats.Conn
 	config           PublisherPublishConfig
 	logger           watermill.LoggerAdapter
-	js               nats.JetStream
+	js               nats.JetStreamContext
 	topicInterpreter *topicInterpreter
+	claimChecks      *claimChecks
 }
 
 // NewPublisher creates a new Publisher.
@@ -128,6 +168,7 @@ func NewPublisherWithNatsConn(conn *nats.Conn, config PublisherPublishConfig, lo
 		logger:           logger,
 		js:               js,
 		topicInterpreter: newTopicInterpreter(js, config.SubjectCalculator),
+		claimChecks:      newClaimChecks(js),
 	}, nil
 }
 
@@ -144,32 +185,121 @@ func (p *Publisher) Publish(topic string, messages ...*message.Message) error {
 	}
 
 	for _, msg := range messages {
-		messageFields := watermill.LogFields{
-			"message_uuid": msg.UUID,
-			"topic_name":   topic,
+		if err := p.publish(topic, msg); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		p.logger.Trace("Publishing message", messageFields)
+func (p *Publisher) publish(topic string, msg *message.Message) (err error) {
+	if p.config.Metrics != nil {
+		start := time.Now()
+		defer func() {
+			p.config.Metrics.MessagePublished(msg.Context(), topic, time.Since(start), err)
+		}()
+	}
 
-		natsMsg, err := p.config.Marshaler.Marshal(topic, msg)
-		if err != nil {
+	messageFields := watermill.LogFields{
+		"message_uuid": msg.UUID,
+		"topic_name":   topic,
+	}
+
+	p.logger.Trace("Publishing message", messageFields)
+
+	natsMsg, err := p.config.Marshaler.Marshal(topic, msg)
+	if err != nil {
+		return err
+	}
+
+	if p.config.Scheduling != nil {
+		if err := p.schedule(topic, msg, natsMsg); err != nil {
 			return err
 		}
+	}
+
+	if p.config.TrackMsgId {
+		if natsMsg.Header == nil {
+			natsMsg.Header = make(nats.Header)
+		}
+		natsMsg.Header.Set(nats.MsgIdHdr, msg.UUID)
+	}
+
+	var endSpan func(*nats.PubAck, error)
+	if p.config.Tracer != nil {
+		endSpan = p.config.Tracer.StartPublish(msg.Context(), topic, msg, natsMsg)
+	}
+
+	// all headers are set before, so the size of the published message is known
+	claimChecked := false
+	if p.config.ClaimCheck != nil {
+		claimChecked, err = p.claimChecks.store(*p.config.ClaimCheck, natsMsg, p.conn.MaxPayload(), p.config.AutoProvision)
+		if err != nil {
+			if endSpan != nil {
+				endSpan(nil, err)
+			}
+			return errors.Wrap(err, "cannot claim-check message")
+		}
+	}
 
-		publishOpts := p.config.PublishOptions
+	ack, err := p.js.PublishMsg(natsMsg, p.config.PublishOptions...)
 
-		if p.config.TrackMsgId {
-			publishOpts = append(publishOpts, nats.MsgId(msg.UUID))
+	if endSpan != nil {
+		endSpan(ack, err)
+	}
+
+	if err != nil {
+		if claimChecked {
+			if err := p.claimChecks.delete(natsMsg); err != nil {
+				p.logger.Error("Cannot delete claim-checked message data", err, messageFields)
+			}
 		}
 
-		if _, err := p.js.PublishMsg(natsMsg, publishOpts...); err != nil {
-			return errors.Wrap(err, "sending message failed")
+		return errors.Wrap(err, "sending message failed")
+	}
+
+	return nil
+}
+
+// schedule redirects natsMsg to the scheduling stream, when msg should be delivered later.
+func (p *Publisher) schedule(topic string, msg *message.Message, natsMsg *nats.Msg) error {
+	at, scheduled, err := deliverAt(msg)
+	if err != nil || !scheduled {
+		return err
+	}
+
+	if p.config.AutoProvision {
+		if err := p.config.Scheduling.ensureStream(p.js); err != nil {
+			return errors.Wrap(err, "cannot initialize scheduling stream")
 		}
 	}
 
+	p.config.Scheduling.schedule(natsMsg, topic, msg.UUID, at)
+
 	return nil
 }
 
+// CancelScheduled cancels the delivery of the scheduled message with the given UUID.
+// ErrScheduledMessageNotFound is returned when the message is not scheduled or was already released.
+func (p *Publisher) CancelScheduled(uuid string) error {
+	if p.config.Scheduling == nil {
+		return errors.New("PublisherConfig.Scheduling is missing")
+	}
+
+	return p.config.Scheduling.cancel(p.js, uuid)
+}
+
+// Ready returns true when the connection of the publisher is established.
+func (p *Publisher) Ready() bool {
+	return p.conn.IsConnected()
+}
+
+// Health reports the state of the connection and the JetStream account.
+func (p *Publisher) Health(ctx context.Context) HealthStatus {
+	return connectionHealth(ctx, p.conn, p.js)
+}
+
 // Close closes the publisher and the underlying connection
 func (p *Publisher) Close() error {
 	p.logger.Trace("Closing publisher", nil)
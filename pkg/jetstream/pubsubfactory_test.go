@@ -1,8 +1,11 @@
 package jetstream_test
 
 import (
+	"context"
+	"crypto/rand"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +15,7 @@ import (
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/ThreeDotsLabs/watermill/pubsub/tests"
 	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -48,10 +52,7 @@ func newPubSub(t *testing.T, clientID string, queueName string, exactlyOnce bool
 
 	logger := watermill.NewStdLogger(strings.ToLower(debug) == "true", strings.ToLower(trace) == "true")
 
-	natsURL := os.Getenv("WATERMILL_TEST_NATS_URL")
-	if natsURL == "" {
-		natsURL = nats.DefaultURL
-	}
+	natsURL := natsTestURL()
 
 	options := []nats.Option{
 		nats.RetryOnFailedConnect(true),
@@ -126,3 +127,147 @@ func createPubSubWithExactlyOnce(t *testing.T) (message.Publisher, message.Subsc
 func createPubSubWithConsumerGroupWithExactlyOnce(t *testing.T, consumerGroup string) (message.Publisher, message.Subscriber) {
 	return newPubSub(t, watermill.NewUUID(), consumerGroup, true)
 }
+
+func natsTestURL() string {
+	natsURL := os.Getenv("WATERMILL_TEST_NATS_URL")
+	if natsURL == "" {
+		natsURL = nats.DefaultURL
+	}
+
+	return natsURL
+}
+
+// natsTestConn connects to the test server, the connection is closed when the test ends.
+func natsTestConn(t *testing.T) (*nats.Conn, nats.JetStreamContext) {
+	conn, err := nats.Connect(natsTestURL())
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+
+	js, err := conn.JetStream()
+	require.NoError(t, err)
+
+	return conn, js
+}
+
+// newTestPublisher creates a publisher using NATSMarshaler with AutoProvision, configure can change the config.
+// The publisher is closed when the test ends.
+func newTestPublisher(t *testing.T, configure func(config *jetstream.PublisherConfig)) *jetstream.Publisher {
+	config := jetstream.PublisherConfig{
+		URL:           natsTestURL(),
+		Marshaler:     &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+	}
+	if configure != nil {
+		configure(&config)
+	}
+
+	pub, err := jetstream.NewPublisher(config, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = pub.Close()
+	})
+
+	return pub
+}
+
+func natsTestSetup(t *testing.T) (string, nats.JetStreamContext, *jetstream.Publisher) {
+	_, js := natsTestConn(t)
+
+	return natsTestURL(), js, newTestPublisher(t, nil)
+}
+
+func requireMessage(t *testing.T, ctx context.Context, messages <-chan *message.Message, expected *message.Message) {
+	t.Helper()
+
+	select {
+	case received := <-messages:
+		assert.Equal(t, expected.UUID, received.UUID)
+		received.Ack()
+	case <-ctx.Done():
+		t.Fatalf("message %s not received", expected.UUID)
+	}
+}
+
+func requireNoMessage(t *testing.T, messages <-chan *message.Message) {
+	t.Helper()
+
+	select {
+	case received := <-messages:
+		t.Fatalf("unexpected message %s", received.UUID)
+	case <-time.After(time.Second):
+		// expected
+	}
+}
+
+func sampleMessage(plSize int) *message.Message {
+	pl := make([]byte, plSize)
+
+	_, _ = rand.Read(pl)
+
+	msg := message.NewMessage(watermill.NewUUID(), pl)
+
+	// add some metadata
+	for i := 0; i < 10; i++ {
+		msg.Metadata.Set(watermill.NewUUID(), watermill.NewUUID())
+	}
+
+	return msg
+}
+
+// countingMetrics counts the calls of each jetstream.Metrics method.
+type countingMetrics struct {
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+func newCountingMetrics() *countingMetrics {
+	return &countingMetrics{counts: map[string]int{}}
+}
+
+func (m *countingMetrics) add(name string, delta int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.counts[name] += delta
+}
+
+func (m *countingMetrics) count(name string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.counts[name]
+}
+
+func (m *countingMetrics) MessagePublished(context.Context, string, time.Duration, error) {
+	m.add("published", 1)
+}
+
+func (m *countingMetrics) MessageReceived(context.Context, string) {
+	m.add("received", 1)
+}
+
+func (m *countingMetrics) MessageUnmarshalFailed(context.Context, string) {
+	m.add("unmarshal_failed", 1)
+}
+
+func (m *countingMetrics) MessageAcked(context.Context, string) {
+	m.add("acked", 1)
+}
+
+func (m *countingMetrics) MessageNacked(context.Context, string, time.Duration) {
+	m.add("nacked", 1)
+}
+
+func (m *countingMetrics) MessageTermed(context.Context, string) {
+	m.add("termed", 1)
+}
+
+func (m *countingMetrics) MessageAckTimedOut(context.Context, string) {
+	m.add("ack_timed_out", 1)
+}
+
+func (m *countingMetrics) InFlightChanged(_ context.Context, _ string, delta int64) {
+	m.add("in_flight", int(delta))
+}
+
+func (m *countingMetrics) ConsumerLag(context.Context, string, uint64) {}
@@ -0,0 +1,100 @@
+package jetstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit is a token bucket limit of messages sent to the output channel of a subscription.
+type RateLimit struct {
+	// MessagesPerSecond is the rate at which messages are sent.
+	MessagesPerSecond float64
+
+	// Burst is the maximum number of messages sent at once (defaults to 1).
+	Burst int
+}
+
+func (r RateLimit) burst() int {
+	if r.Burst <= 0 {
+		return 1
+	}
+
+	return r.Burst
+}
+
+// Validate ensures configuration is valid before use
+func (r RateLimit) Validate() error {
+	if r.MessagesPerSecond <= 0 {
+		return errors.New("RateLimit.MessagesPerSecond must be greater than 0")
+	}
+
+	return nil
+}
+
+// rateLimitOptions returns the subscribe options applying the server-side rate limit to the consumer.
+func (c SubscriberSubscriptionConfig) rateLimitOptions() []nats.SubOpt {
+	if c.ConsumerRateLimit == 0 {
+		return nil
+	}
+
+	return []nats.SubOpt{nats.RateLimit(c.ConsumerRateLimit)}
+}
+
+// rateLimit returns the rate limit of topic, or nil when it is not limited.
+func (c SubscriberSubscriptionConfig) rateLimit(topic string) *RateLimit {
+	if limit, ok := c.TopicRateLimits[topic]; ok {
+		return &limit
+	}
+
+	return c.RateLimit
+}
+
+// rateLimiters keeps a token bucket per topic and limit, shared by all subscriptions of the topic with the same limit.
+type rateLimiters struct {
+	limiters sync.Map
+}
+
+type rateLimiterKey struct {
+	topic             string
+	messagesPerSecond float64
+	burst             int
+}
+
+// wait blocks until a message of topic can be sent, it returns false when ctx is done or closing is closed first.
+func (r *rateLimiters) wait(ctx context.Context, closing chan struct{}, topic string, limit *RateLimit) bool {
+	if limit == nil {
+		return true
+	}
+
+	key := rateLimiterKey{topic: topic, messagesPerSecond: limit.MessagesPerSecond, burst: limit.burst()}
+
+	limiter, ok := r.limiters.Load(key)
+	if !ok {
+		limiter, _ = r.limiters.LoadOrStore(key, rate.NewLimiter(rate.Limit(key.messagesPerSecond), key.burst))
+	}
+
+	reservation := limiter.(*rate.Limiter).Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-closing:
+	case <-ctx.Done():
+	}
+
+	reservation.Cancel()
+
+	return false
+}
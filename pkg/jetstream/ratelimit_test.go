@@ -0,0 +1,131 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriber_Rate_Limit(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	limitedTopic := "rate_limited_" + watermill.NewShortUUID()
+	overriddenTopic := "rate_limit_overridden_" + watermill.NewShortUUID()
+	durableName := "durable_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(limitedTopic)
+		_ = js.DeleteStream(overriddenTopic)
+	}()
+
+	const messagesCount = 6
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsURL,
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		DurableName:   durableName,
+		RateLimit: &jetstream.RateLimit{
+			MessagesPerSecond: 10,
+			Burst:             1,
+		},
+		TopicRateLimits: map[string]jetstream.RateLimit{
+			overriddenTopic: {MessagesPerSecond: 1000, Burst: messagesCount},
+		},
+		ConsumerRateLimit: 1024 * 1024,
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	receiveAll := func(topic string) time.Duration {
+		for i := 0; i < messagesCount; i++ {
+			require.NoError(t, pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+		}
+
+		messages, err := sub.Subscribe(ctx, topic)
+		require.NoError(t, err)
+
+		start := time.Now()
+		for i := 0; i < messagesCount; i++ {
+			select {
+			case msg := <-messages:
+				msg.Ack()
+			case <-ctx.Done():
+				t.Fatal("messages not received")
+			}
+		}
+
+		return time.Since(start)
+	}
+
+	// the first message uses the burst, the next ones wait for 100ms each
+	assert.GreaterOrEqual(t, receiveAll(limitedTopic), 400*time.Millisecond)
+	assert.Less(t, receiveAll(overriddenTopic), 400*time.Millisecond)
+
+	info, err := js.ConsumerInfo(limitedTopic, durableName)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1024*1024), info.Config.RateLimit)
+}
+
+func TestSubscriber_Rate_Limit_Per_Subscription(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	topic := "rate_limited_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	const messagesCount = 6
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsURL,
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		RateLimit: &jetstream.RateLimit{
+			MessagesPerSecond: 10,
+			Burst:             1,
+		},
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for i := 0; i < messagesCount; i++ {
+		require.NoError(t, pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+	}
+
+	receiveAll := func(messages <-chan *message.Message) time.Duration {
+		start := time.Now()
+		for i := 0; i < messagesCount; i++ {
+			select {
+			case msg := <-messages:
+				msg.Ack()
+			case <-ctx.Done():
+				t.Fatal("messages not received")
+			}
+		}
+
+		return time.Since(start)
+	}
+
+	limited, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, receiveAll(limited), 400*time.Millisecond)
+
+	// the limiter of the first subscription must not be reused
+	overridden, err := sub.SubscribeWithConfig(ctx, topic, func(topic string, config *jetstream.SubscriberSubscriptionConfig) {
+		config.RateLimit = &jetstream.RateLimit{MessagesPerSecond: 1000, Burst: messagesCount}
+	})
+	require.NoError(t, err)
+	assert.Less(t, receiveAll(overridden), 400*time.Millisecond)
+}
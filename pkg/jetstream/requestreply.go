@@ -0,0 +1,222 @@
+package jetstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ReplyTopicMetadataKey is the metadata key with the topic where the reply to a request should be published.
+	ReplyTopicMetadataKey = "_watermill_reply_topic"
+
+	// RequestIDMetadataKey is the metadata key with the ID correlating a reply with its request.
+	RequestIDMetadataKey = "_watermill_request_id"
+)
+
+var (
+	// ErrRequestTimeout is returned by Requester.Request when no reply was received in time.
+	ErrRequestTimeout = errors.New("request timed out")
+
+	// ErrNoReplyTopic is returned by Reply when the request has no reply topic.
+	ErrNoReplyTopic = errors.New("request has no reply topic")
+
+	// ErrRequesterClosed is returned by Requester.Request when the requester is closed.
+	ErrRequesterClosed = errors.New("requester closed")
+)
+
+// RequesterConfig is the configuration to create a requester.
+type RequesterConfig struct {
+	// ReplyTopic is the topic where replies are published.
+	//
+	// Every requester instance should use its own reply topic. When several instances share a topic,
+	// their subscriber must deliver all replies to every instance (no queue group and no shared durable name),
+	// replies to requests of other instances are ignored.
+	ReplyTopic string
+
+	// Timeout is the default time to wait for a reply (defaults to 30s).
+	// Request returns earlier when its context is done.
+	Timeout time.Duration
+}
+
+func (c *RequesterConfig) setDefaults() {
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Second
+	}
+}
+
+// Validate ensures configuration is valid before use
+func (c RequesterConfig) Validate() error {
+	if c.ReplyTopic == "" {
+		return errors.New("RequesterConfig.ReplyTopic is missing")
+	}
+
+	return nil
+}
+
+// Requester sends requests (for example commands) as persisted messages and waits for correlated replies.
+//
+// Requests and replies are regular messages, so they work with all Marshalers and are redelivered
+// until handled. The reply topic and the request ID are stored in metadata.
+type Requester struct {
+	publisher message.Publisher
+	config    RequesterConfig
+	logger    watermill.LoggerAdapter
+
+	pendingLock sync.Mutex
+	pending     map[string]chan *message.Message
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewRequester creates a new Requester, publishing requests with publisher and receiving replies with subscriber.
+func NewRequester(
+	publisher message.Publisher,
+	subscriber message.Subscriber,
+	config RequesterConfig,
+	logger watermill.LoggerAdapter,
+) (*Requester, error) {
+	config.setDefaults()
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+
+	r := &Requester{
+		publisher: publisher,
+		config:    config,
+		logger:    logger,
+		pending:   make(map[string]chan *message.Message),
+		closing:   make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	replies, err := subscriber.Subscribe(ctx, config.ReplyTopic)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "cannot subscribe to reply topic")
+	}
+
+	go func() {
+		defer close(r.closed)
+		defer cancel()
+
+		r.handleReplies(replies)
+	}()
+
+	return r, nil
+}
+
+func (r *Requester) handleReplies(replies <-chan *message.Message) {
+	for {
+		select {
+		case <-r.closing:
+			return
+		case reply, ok := <-replies:
+			if !ok {
+				return
+			}
+
+			requestID := reply.Metadata.Get(RequestIDMetadataKey)
+
+			r.pendingLock.Lock()
+			waiting, ok := r.pending[requestID]
+			delete(r.pending, requestID)
+			r.pendingLock.Unlock()
+
+			// replies are always acked, a reply to an expired request will not be awaited anymore
+			reply.Ack()
+
+			if !ok {
+				r.logger.Debug("Ignoring reply to unknown request", watermill.LogFields{
+					"message_uuid": reply.UUID,
+					"request_id":   requestID,
+				})
+				continue
+			}
+
+			waiting <- reply
+		}
+	}
+}
+
+// Request publishes msg to topic and waits for its reply.
+//
+// msg metadata is modified to carry the reply topic and the request ID.
+// ErrRequestTimeout is returned when no reply is received within the configured timeout.
+func (r *Requester) Request(ctx context.Context, topic string, msg *message.Message) (*message.Message, error) {
+	requestID := watermill.NewUUID()
+
+	msg.Metadata.Set(ReplyTopicMetadataKey, r.config.ReplyTopic)
+	msg.Metadata.Set(RequestIDMetadataKey, requestID)
+
+	// buffered, so handleReplies never blocks on a request which gave up waiting
+	waiting := make(chan *message.Message, 1)
+
+	r.pendingLock.Lock()
+	r.pending[requestID] = waiting
+	r.pendingLock.Unlock()
+
+	defer func() {
+		r.pendingLock.Lock()
+		delete(r.pending, requestID)
+		r.pendingLock.Unlock()
+	}()
+
+	if err := r.publisher.Publish(topic, msg); err != nil {
+		return nil, errors.Wrap(err, "cannot publish request")
+	}
+
+	timeout := time.NewTimer(r.config.Timeout)
+	defer timeout.Stop()
+
+	select {
+	case reply := <-waiting:
+		return reply, nil
+	case <-timeout.C:
+		return nil, ErrRequestTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-r.closing:
+		return nil, ErrRequesterClosed
+	}
+}
+
+// Close stops receiving replies, pending requests return ErrRequesterClosed.
+// It doesn't close the publisher and the subscriber.
+func (r *Requester) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closing)
+	})
+
+	<-r.closed
+
+	return nil
+}
+
+// Reply publishes reply to the reply topic of request, correlated with it.
+//
+// It is meant to be called by the handler of the request, before acking it.
+// ErrNoReplyTopic is returned when the request was not sent by Requester.
+func Reply(publisher message.Publisher, request *message.Message, reply *message.Message) error {
+	replyTopic := request.Metadata.Get(ReplyTopicMetadataKey)
+	if replyTopic == "" {
+		return ErrNoReplyTopic
+	}
+
+	reply.Metadata.Set(RequestIDMetadataKey, request.Metadata.Get(RequestIDMetadataKey))
+
+	return publisher.Publish(replyTopic, reply)
+}
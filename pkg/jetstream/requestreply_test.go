@@ -0,0 +1,79 @@
+package jetstream_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequester(t *testing.T) {
+	_, js := natsTestConn(t)
+
+	commandsTopic := "commands_" + watermill.NewShortUUID()
+	replyTopic := "replies_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(commandsTopic)
+		_ = js.DeleteStream(replyTopic)
+	}()
+
+	logger := watermill.NewStdLogger(false, false)
+
+	pub := newTestPublisher(t, func(config *jetstream.PublisherConfig) {
+		config.Marshaler = &jetstream.GobMarshaler{}
+	})
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsTestURL(),
+		Unmarshaler:   &jetstream.GobMarshaler{},
+		AutoProvision: true,
+	}, logger)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	require.NoError(t, sub.SubscribeInitialize(replyTopic))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	commands, err := sub.Subscribe(ctx, commandsTopic)
+	require.NoError(t, err)
+
+	go func() {
+		for command := range commands {
+			if string(command.Payload) == "ignore" {
+				command.Ack()
+				continue
+			}
+
+			reply := message.NewMessage(watermill.NewUUID(), bytes.ToUpper(command.Payload))
+			if assert.NoError(t, jetstream.Reply(pub, command, reply)) {
+				command.Ack()
+			} else {
+				command.Nack()
+			}
+		}
+	}()
+
+	requester, err := jetstream.NewRequester(pub, sub, jetstream.RequesterConfig{
+		ReplyTopic: replyTopic,
+		Timeout:    time.Second,
+	}, logger)
+	require.NoError(t, err)
+	defer requester.Close()
+
+	reply, err := requester.Request(ctx, commandsTopic, message.NewMessage(watermill.NewUUID(), []byte("hello")))
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", string(reply.Payload))
+
+	_, err = requester.Request(ctx, commandsTopic, message.NewMessage(watermill.NewUUID(), []byte("ignore")))
+	require.ErrorIs(t, err, jetstream.ErrRequestTimeout)
+
+	require.ErrorIs(t, jetstream.Reply(pub, message.NewMessage(watermill.NewUUID(), nil), message.NewMessage(watermill.NewUUID(), nil)), jetstream.ErrNoReplyTopic)
+}
@@ -0,0 +1,377 @@
+package jetstream
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// DeliverAtMetadataKey is the metadata key with the time (RFC 3339) when a message should be delivered.
+// It is used by Publisher when PublisherConfig.Scheduling is set, it is ignored otherwise.
+const DeliverAtMetadataKey = "deliver_at"
+
+const (
+	// ScheduledTopicHdr is the header storing the target topic of a scheduled message.
+	ScheduledTopicHdr = "_watermill_scheduled_topic"
+
+	// ScheduledDeliverAtHdr is the header storing the delivery time of a scheduled message.
+	ScheduledDeliverAtHdr = "_watermill_scheduled_deliver_at"
+)
+
+// DefaultSchedulingStream is the default name of the stream storing scheduled messages.
+const DefaultSchedulingStream = "watermill_scheduled"
+
+// schedulingStreamDescription marks scheduling streams, so Admin doesn't list them as topics.
+const schedulingStreamDescription = "watermill-jetstream scheduled messages"
+
+// ErrScheduledMessageNotFound is returned when cancelling a message which is not scheduled (anymore).
+var ErrScheduledMessageNotFound = errors.New("scheduled message not found")
+
+// SetDeliverAt schedules msg to be delivered at t.
+func SetDeliverAt(msg *message.Message, t time.Time) {
+	msg.Metadata.Set(DeliverAtMetadataKey, t.UTC().Format(time.RFC3339Nano))
+}
+
+// SchedulingConfig configures the stream storing scheduled messages.
+type SchedulingConfig struct {
+	// Stream is the name of the stream (defaults to DefaultSchedulingStream).
+	// Messages are stored under "{Stream}.{message UUID}" subjects, the stream uses work-queue retention.
+	Stream string
+}
+
+func (c SchedulingConfig) stream() string {
+	if c.Stream == "" {
+		return DefaultSchedulingStream
+	}
+
+	return c.Stream
+}
+
+func (c SchedulingConfig) subject(uuid string) string {
+	return c.stream() + "." + uuid
+}
+
+func (c SchedulingConfig) subjects() string {
+	return c.stream() + ".*"
+}
+
+// ensureStream creates the scheduling stream, released messages are removed from it once acked by the scheduler.
+func (c SchedulingConfig) ensureStream(js nats.JetStreamManager) error {
+	_, err := js.StreamInfo(c.stream())
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:        c.stream(),
+			Description: schedulingStreamDescription,
+			Subjects:    []string{c.subjects()},
+			Retention:   nats.WorkQueuePolicy,
+		})
+	}
+
+	return err
+}
+
+// deliverAt returns the time when msg should be delivered, or false when it should be delivered immediately.
+func deliverAt(msg *message.Message) (time.Time, bool, error) {
+	value := msg.Metadata.Get(DeliverAtMetadataKey)
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false, errors.Wrapf(err, "invalid %s metadata", DeliverAtMetadataKey)
+	}
+
+	return t, time.Now().Before(t), nil
+}
+
+// schedule changes natsMsg to be stored in the scheduling stream.
+func (c SchedulingConfig) schedule(natsMsg *nats.Msg, topic, uuid string, at time.Time) {
+	if natsMsg.Header == nil {
+		natsMsg.Header = make(nats.Header)
+	}
+
+	natsMsg.Header.Set(ScheduledTopicHdr, topic)
+	natsMsg.Header.Set(ScheduledDeliverAtHdr, at.UTC().Format(time.RFC3339Nano))
+	natsMsg.Subject = c.subject(uuid)
+}
+
+// lastMsgGetter is implemented by the JetStream context of nats.go, but it is not a part of nats.JetStreamManager.
+type lastMsgGetter interface {
+	GetLastMsg(name, subject string, opts ...nats.JSOpt) (*nats.RawStreamMsg, error)
+}
+
+// cancel removes the scheduled message with the given UUID from the scheduling stream.
+func (c SchedulingConfig) cancel(js nats.JetStreamManager, uuid string) error {
+	getter, ok := js.(lastMsgGetter)
+	if !ok {
+		return errors.New("JetStream context does not support getting messages by subject")
+	}
+
+	stored, err := getter.GetLastMsg(c.stream(), c.subject(uuid))
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return ErrScheduledMessageNotFound
+	} else if err != nil {
+		return errors.Wrapf(err, "cannot get scheduled message %s", uuid)
+	}
+
+	if err := js.DeleteMsg(c.stream(), stored.Sequence); err != nil {
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			return ErrScheduledMessageNotFound
+		}
+		return errors.Wrapf(err, "cannot delete scheduled message %s", uuid)
+	}
+
+	return nil
+}
+
+// SchedulerConfig is the configuration to create a scheduler.
+type SchedulerConfig struct {
+	// Scheduling configures the scheduling stream, it must be the same as in PublisherConfig.
+	Scheduling SchedulingConfig
+
+	// DurableName is the name of the durable consumer reading the scheduling stream (defaults to "watermill_scheduler").
+	// Schedulers with the same durable name share the work.
+	DurableName string
+
+	// AutoProvision creates the scheduling stream when it is missing.
+	AutoProvision bool
+
+	// BatchSize is the maximum number of scheduled messages fetched at once (defaults to 100).
+	BatchSize int
+
+	// PollInterval is the maximum time to wait for scheduled messages in a single fetch (defaults to 5s).
+	PollInterval time.Duration
+
+	// AckWaitTimeout is the time after which a message not released is redelivered to a scheduler (defaults to 30s).
+	AckWaitTimeout time.Duration
+
+	// RetryDelay is the delay before releasing a message is retried after a failed publish (defaults to 1s).
+	RetryDelay time.Duration
+
+	// JetstreamOptions are custom Jetstream options for a connection.
+	JetstreamOptions []nats.JSOpt
+}
+
+func (c *SchedulerConfig) setDefaults() {
+	if c.DurableName == "" {
+		c.DurableName = "watermill_scheduler"
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = 5 * time.Second
+	}
+	if c.AckWaitTimeout <= 0 {
+		c.AckWaitTimeout = 30 * time.Second
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = time.Second
+	}
+}
+
+// Scheduler releases messages scheduled by Publisher to their target topics once they are due.
+//
+// Scheduled messages are read with a durable consumer and are not acked (removed from the stream) until released,
+// so no message is lost when the scheduler is restarted. Messages which are not due yet are
+// nacked with a delay until their delivery time.
+//
+// Messages waiting for their delivery time stay pending acknowledgement, so the consumer is created
+// without an ack pending limit; otherwise messages scheduled far ahead would block messages due sooner.
+// All scheduled messages are tracked by the server as pending, which limits the practical number of
+// messages scheduled at once to what the server can keep in memory.
+type Scheduler struct {
+	js     nats.JetStreamContext
+	config SchedulerConfig
+	logger watermill.LoggerAdapter
+
+	runningLock sync.Mutex
+	running     bool
+}
+
+// NewScheduler creates a new Scheduler with the provided nats connection.
+func NewScheduler(conn *nats.Conn, config SchedulerConfig, logger watermill.LoggerAdapter) (*Scheduler, error) {
+	config.setDefaults()
+
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+
+	js, err := conn.JetStream(config.JetstreamOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scheduler{
+		js:     js,
+		config: config,
+		logger: logger,
+	}, nil
+}
+
+// Run releases due messages until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.runningLock.Lock()
+	if s.running {
+		s.runningLock.Unlock()
+		return errors.New("scheduler is already running")
+	}
+	s.running = true
+	s.runningLock.Unlock()
+
+	defer func() {
+		s.runningLock.Lock()
+		s.running = false
+		s.runningLock.Unlock()
+	}()
+
+	if s.config.AutoProvision {
+		if err := s.config.Scheduling.ensureStream(s.js); err != nil {
+			return errors.Wrap(err, "cannot initialize scheduling stream")
+		}
+	}
+
+	if err := s.ensureConsumer(); err != nil {
+		return errors.Wrap(err, "cannot initialize scheduler consumer")
+	}
+
+	// binding to the consumer keeps it on unsubscribe, so scheduled messages survive restarts
+	sub, err := s.js.PullSubscribe(
+		s.config.Scheduling.subjects(),
+		s.config.DurableName,
+		nats.Bind(s.config.Scheduling.stream(), s.config.DurableName),
+	)
+	if err != nil {
+		return errors.Wrap(err, "cannot subscribe to scheduling stream")
+	}
+	defer func() {
+		if err := sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+			s.logger.Error("Cannot unsubscribe from scheduling stream", err, nil)
+		}
+	}()
+
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, s.config.PollInterval)
+		msgs, err := sub.Fetch(s.config.BatchSize, nats.Context(fetchCtx))
+		cancel()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, nats.ErrTimeout) {
+			s.logger.Error("Cannot fetch scheduled messages", err, nil)
+
+			select {
+			case <-time.After(s.config.RetryDelay):
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			s.process(msg)
+		}
+	}
+}
+
+// ensureConsumer creates the scheduler consumer, consumers created with an ack pending limit are updated to have none.
+func (s *Scheduler) ensureConsumer() error {
+	info, err := s.js.ConsumerInfo(s.config.Scheduling.stream(), s.config.DurableName)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = s.js.AddConsumer(s.config.Scheduling.stream(), &nats.ConsumerConfig{
+			Durable:       s.config.DurableName,
+			FilterSubject: s.config.Scheduling.subjects(),
+			AckPolicy:     nats.AckExplicitPolicy,
+			AckWait:       s.config.AckWaitTimeout,
+			MaxAckPending: -1,
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Config.MaxAckPending != -1 {
+		config := info.Config
+		config.MaxAckPending = -1
+
+		_, err = s.js.UpdateConsumer(s.config.Scheduling.stream(), &config)
+	}
+
+	return err
+}
+
+func (s *Scheduler) process(msg *nats.Msg) {
+	uuid := strings.TrimPrefix(msg.Subject, s.config.Scheduling.stream()+".")
+	topic := msg.Header.Get(ScheduledTopicHdr)
+
+	logFields := watermill.LogFields{
+		"message_uuid": uuid,
+		"topic":        topic,
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, msg.Header.Get(ScheduledDeliverAtHdr))
+	if err != nil || topic == "" {
+		s.logger.Error("Invalid scheduled message, terminating", err, logFields)
+
+		if err := msg.Term(); err != nil {
+			s.logger.Error("Cannot send term", err, logFields)
+		}
+		return
+	}
+
+	if wait := time.Until(at); wait > 0 {
+		s.logger.Trace("Scheduled message not due yet", logFields.Add(watermill.LogFields{"wait": wait.String()}))
+
+		if err := msg.NakWithDelay(wait); err != nil {
+			s.logger.Error("Cannot send nak", err, logFields)
+		}
+		return
+	}
+
+	if err := s.release(msg, topic, uuid); err != nil {
+		s.logger.Error("Cannot release scheduled message", err, logFields)
+
+		if err := msg.NakWithDelay(s.config.RetryDelay); err != nil {
+			s.logger.Error("Cannot send nak", err, logFields)
+		}
+		return
+	}
+
+	if err := msg.AckSync(); err != nil {
+		// the message will be released again, which is deduplicated by its message ID
+		s.logger.Error("Cannot send ack", err, logFields)
+		return
+	}
+
+	s.logger.Trace("Scheduled message released", logFields)
+}
+
+func (s *Scheduler) release(msg *nats.Msg, topic, uuid string) error {
+	released := withData(msg, msg.Data, ScheduledTopicHdr, ScheduledDeliverAtHdr)
+	released.Subject = topic
+	released.Reply = ""
+	released.Sub = nil
+
+	if released.Header.Get(nats.MsgIdHdr) == "" {
+		released.Header.Set(nats.MsgIdHdr, uuid)
+	}
+
+	_, err := s.js.PublishMsg(released)
+
+	return err
+}
+
+// CancelScheduled cancels the delivery of the scheduled message with the given UUID.
+// ErrScheduledMessageNotFound is returned when the message is not scheduled or was already released.
+func (s *Scheduler) CancelScheduled(uuid string) error {
+	return s.config.Scheduling.cancel(s.js, uuid)
+}
@@ -0,0 +1,187 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler(t *testing.T) {
+	conn, js := natsTestConn(t)
+
+	topic := "scheduled_" + watermill.NewShortUUID()
+	scheduling := &jetstream.SchedulingConfig{Stream: topic + "_scheduling"}
+	defer func() {
+		_ = js.DeleteStream(scheduling.Stream)
+		_ = js.DeleteStream(topic)
+	}()
+
+	logger := watermill.NewStdLogger(false, false)
+
+	pub := newTestPublisher(t, func(config *jetstream.PublisherConfig) {
+		config.Scheduling = scheduling
+	})
+
+	deliverAt := time.Now().Add(2 * time.Second)
+
+	immediateMsg := message.NewMessage(watermill.NewUUID(), []byte("immediate"))
+
+	scheduledMsg := message.NewMessage(watermill.NewUUID(), []byte("scheduled"))
+	jetstream.SetDeliverAt(scheduledMsg, deliverAt)
+
+	cancelledMsg := message.NewMessage(watermill.NewUUID(), []byte("cancelled"))
+	jetstream.SetDeliverAt(cancelledMsg, deliverAt)
+
+	require.NoError(t, pub.Publish(topic, scheduledMsg, cancelledMsg, immediateMsg))
+
+	require.NoError(t, pub.CancelScheduled(cancelledMsg.UUID))
+	require.ErrorIs(t, pub.CancelScheduled(cancelledMsg.UUID), jetstream.ErrScheduledMessageNotFound)
+
+	schedulerConfig := jetstream.SchedulerConfig{
+		Scheduling:    *scheduling,
+		AutoProvision: true,
+		PollInterval:  100 * time.Millisecond,
+	}
+
+	// the first scheduler is stopped before the message is due, to check that scheduled messages survive restarts
+	scheduler, err := jetstream.NewScheduler(conn, schedulerConfig, logger)
+	require.NoError(t, err)
+
+	schedulerCtx, cancelScheduler := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	require.NoError(t, scheduler.Run(schedulerCtx))
+	cancelScheduler()
+
+	scheduler, err = jetstream.NewScheduler(conn, schedulerConfig, logger)
+	require.NoError(t, err)
+
+	schedulerCtx, cancelScheduler = context.WithCancel(context.Background())
+	defer cancelScheduler()
+
+	go func() {
+		assert.NoError(t, scheduler.Run(schedulerCtx))
+	}()
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsTestURL(),
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+	}, logger)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	for _, expected := range []*message.Message{immediateMsg, scheduledMsg} {
+		select {
+		case received := <-messages:
+			assert.Equal(t, expected.UUID, received.UUID)
+			received.Ack()
+
+			if expected == scheduledMsg {
+				assert.False(t, time.Now().Before(deliverAt), "message delivered too early")
+			}
+		case <-ctx.Done():
+			t.Fatal("message not received")
+		}
+	}
+
+	select {
+	case received := <-messages:
+		t.Fatalf("unexpected message %s", received.UUID)
+	case <-time.After(time.Second):
+		// cancelled message is not delivered
+	}
+}
+
+func TestScheduler_More_Parked_Messages_Than_Max_Ack_Pending(t *testing.T) {
+	conn, js := natsTestConn(t)
+
+	topic := "scheduled_" + watermill.NewShortUUID()
+	scheduling := &jetstream.SchedulingConfig{Stream: topic + "_scheduling"}
+	defer func() {
+		_ = js.DeleteStream(scheduling.Stream)
+		_ = js.DeleteStream(topic)
+	}()
+
+	logger := watermill.NewStdLogger(false, false)
+
+	pub := newTestPublisher(t, func(config *jetstream.PublisherConfig) {
+		config.Scheduling = scheduling
+	})
+
+	schedulerConfig := jetstream.SchedulerConfig{
+		Scheduling:    *scheduling,
+		DurableName:   "scheduler",
+		AutoProvision: true,
+		PollInterval:  100 * time.Millisecond,
+	}
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:      scheduling.Stream,
+		Subjects:  []string{scheduling.Stream + ".*"},
+		Retention: nats.WorkQueuePolicy,
+	})
+	require.NoError(t, err)
+
+	// a consumer created with an ack pending limit is updated by the scheduler
+	const maxAckPending = 10
+	_, err = js.AddConsumer(scheduling.Stream, &nats.ConsumerConfig{
+		Durable:       schedulerConfig.DurableName,
+		AckPolicy:     nats.AckExplicitPolicy,
+		MaxAckPending: maxAckPending,
+	})
+	require.NoError(t, err)
+
+	var parked []*message.Message
+	for i := 0; i < maxAckPending*2; i++ {
+		msg := message.NewMessage(watermill.NewUUID(), nil)
+		jetstream.SetDeliverAt(msg, time.Now().Add(time.Hour))
+		parked = append(parked, msg)
+	}
+	require.NoError(t, pub.Publish(topic, parked...))
+
+	scheduler, err := jetstream.NewScheduler(conn, schedulerConfig, logger)
+	require.NoError(t, err)
+
+	schedulerCtx, cancelScheduler := context.WithCancel(context.Background())
+	defer cancelScheduler()
+
+	go func() {
+		assert.NoError(t, scheduler.Run(schedulerCtx))
+	}()
+
+	require.Eventually(t, func() bool {
+		info, err := js.ConsumerInfo(scheduling.Stream, schedulerConfig.DurableName)
+		return err == nil && info.NumAckPending == len(parked)
+	}, 10*time.Second, 100*time.Millisecond, "all future messages should be parked")
+
+	dueMsg := message.NewMessage(watermill.NewUUID(), nil)
+	jetstream.SetDeliverAt(dueMsg, time.Now().Add(time.Second))
+	require.NoError(t, pub.Publish(topic, dueMsg))
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsTestURL(),
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+	}, logger)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	requireMessage(t, ctx, messages, dueMsg)
+}
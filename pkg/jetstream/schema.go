@@ -0,0 +1,216 @@
+package jetstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/linkedin/goavro/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaIDHdr is the header used by SchemaMarshaler to store the ID of the schema of the payload.
+// The ID is also available in the unmarshaled message metadata under the same key.
+const SchemaIDHdr = "_watermill_schema_id"
+
+// ErrMissingSchema is returned (wrapped in SchemaError) when a message has no schema ID.
+var ErrMissingSchema = errors.New("message has no schema ID")
+
+// SchemaError is returned when a payload does not match its schema, or the message schema is unknown.
+//
+// It is a PermanentError, so Subscriber terminates such messages instead of letting them be redelivered.
+type SchemaError struct {
+	SchemaID string
+	Err      error
+}
+
+func (e *SchemaError) Error() string {
+	if e.SchemaID == "" {
+		return "invalid schema: " + e.Err.Error()
+	}
+
+	return "invalid schema " + e.SchemaID + ": " + e.Err.Error()
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent returns true, redelivery will not make the payload valid or the schema known.
+func (e *SchemaError) Permanent() bool {
+	return true
+}
+
+// SchemaMarshaler decorates a MarshalerUnmarshaler to validate payloads against schemas from a SchemaRegistry.
+//
+// Marshal looks up the schema of the message subject, rejects payloads which don't match it with SchemaError
+// and stores the schema ID in the SchemaIDHdr header. Unmarshal resolves the writer schema by the ID
+// and validates the payload against it. Handlers can get the writer schema with WriterSchema.
+type SchemaMarshaler struct {
+	// Marshaler is the decorated marshaler.
+	Marshaler MarshalerUnmarshaler
+
+	// Registry provides the schemas.
+	Registry SchemaRegistry
+
+	// Subject returns the schema subject of a message, the topic is used when nil.
+	Subject func(topic string, msg *message.Message) string
+
+	// Version is the schema version used by Marshal, the latest version is used when 0.
+	Version int
+
+	// AllowMissingSchema allows to unmarshal messages without schema ID,
+	// for example written before schema validation was enabled.
+	AllowMissingSchema bool
+
+	validators sync.Map
+}
+
+// Marshal validates the payload of msg and marshals it with the decorated marshaler.
+func (s *SchemaMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, error) {
+	subject := topic
+	if s.Subject != nil {
+		subject = s.Subject(topic, msg)
+	}
+
+	schema, err := s.Registry.Schema(subject, s.Version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get schema of subject %s", subject)
+	}
+
+	if err := s.validate(schema, msg.Payload); err != nil {
+		return nil, err
+	}
+
+	natsMsg, err := s.Marshaler.Marshal(topic, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if natsMsg.Header == nil {
+		natsMsg.Header = make(nats.Header)
+	}
+
+	natsMsg.Header.Set(SchemaIDHdr, schema.ID)
+
+	return natsMsg, nil
+}
+
+// Unmarshal unmarshals natsMsg with the decorated unmarshaler and validates the payload against the writer schema.
+func (s *SchemaMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	schemaID := natsMsg.Header.Get(SchemaIDHdr)
+	if schemaID == "" {
+		if s.AllowMissingSchema {
+			return s.Marshaler.Unmarshal(natsMsg)
+		}
+		return nil, &SchemaError{Err: ErrMissingSchema}
+	}
+
+	schema, err := s.Registry.SchemaByID(schemaID)
+	if errors.Is(err, ErrSchemaNotFound) {
+		return nil, &SchemaError{SchemaID: schemaID, Err: err}
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "cannot get schema %s", schemaID)
+	}
+
+	msg, err := s.Marshaler.Unmarshal(withData(natsMsg, natsMsg.Data, SchemaIDHdr))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.validate(schema, msg.Payload); err != nil {
+		return nil, err
+	}
+
+	if msg.Metadata == nil {
+		msg.Metadata = make(message.Metadata)
+	}
+	msg.Metadata.Set(SchemaIDHdr, schemaID)
+
+	return msg, nil
+}
+
+// WriterSchema returns the schema msg was written with.
+func (s *SchemaMarshaler) WriterSchema(msg *message.Message) (Schema, error) {
+	schemaID := msg.Metadata.Get(SchemaIDHdr)
+	if schemaID == "" {
+		return Schema{}, ErrMissingSchema
+	}
+
+	return s.Registry.SchemaByID(schemaID)
+}
+
+func (s *SchemaMarshaler) validate(schema Schema, payload []byte) error {
+	var v schemaValidator
+
+	if cached, ok := s.validators.Load(schema.ID); ok {
+		v = cached.(schemaValidator)
+	} else {
+		compiled, err := compileSchema(schema)
+		if err != nil {
+			return err
+		}
+
+		s.validators.Store(schema.ID, compiled)
+		v = compiled
+	}
+
+	if err := v(payload); err != nil {
+		return &SchemaError{SchemaID: schema.ID, Err: err}
+	}
+
+	return nil
+}
+
+// schemaValidator returns an error when payload does not match the schema.
+type schemaValidator func(payload []byte) error
+
+func compileSchema(schema Schema) (schemaValidator, error) {
+	switch schema.Type {
+	case AvroSchema:
+		codec, err := goavro.NewCodec(schema.Definition)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid Avro schema %s", schema.ID)
+		}
+
+		return func(payload []byte) error {
+			_, remaining, err := codec.NativeFromBinary(payload)
+			if err != nil {
+				return err
+			}
+			if len(remaining) > 0 {
+				return errors.Errorf("%d unexpected bytes after Avro record", len(remaining))
+			}
+			return nil
+		}, nil
+	case JSONSchema:
+		url := "schema.json"
+
+		compiler := jsonschema.NewCompiler()
+		if err := compiler.AddResource(url, bytes.NewReader([]byte(schema.Definition))); err != nil {
+			return nil, errors.Wrapf(err, "invalid JSON schema %s", schema.ID)
+		}
+
+		compiled, err := compiler.Compile(url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid JSON schema %s", schema.ID)
+		}
+
+		return func(payload []byte) error {
+			decoder := json.NewDecoder(bytes.NewReader(payload))
+			decoder.UseNumber()
+
+			var document interface{}
+			if err := decoder.Decode(&document); err != nil {
+				return errors.Wrap(err, "invalid JSON")
+			}
+
+			return compiled.Validate(document)
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported schema type %q", schema.Type)
+	}
+}
@@ -0,0 +1,200 @@
+package jetstream
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// SchemaType is the format of a schema definition.
+type SchemaType string
+
+const (
+	// AvroSchema is an Apache Avro schema, payloads are Avro binary encoded.
+	AvroSchema SchemaType = "AVRO"
+
+	// JSONSchema is a JSON Schema, payloads are JSON documents.
+	JSONSchema SchemaType = "JSON"
+)
+
+// ErrSchemaNotFound is returned by SchemaRegistry when a schema does not exist.
+var ErrSchemaNotFound = errors.New("schema not found")
+
+// Schema is a version of a schema registered for a subject.
+type Schema struct {
+	// ID identifies the schema version, it is stored in message headers.
+	ID string `json:"-"`
+
+	Subject    string     `json:"subject"`
+	Version    int        `json:"version"`
+	Type       SchemaType `json:"type"`
+	Definition string     `json:"definition"`
+}
+
+// SchemaRegistry provides schemas used by SchemaMarshaler.
+//
+// Schema versions are immutable, so they can be cached by ID.
+type SchemaRegistry interface {
+	// Schema returns the schema of subject in version, or the latest version when version is 0.
+	Schema(subject string, version int) (Schema, error)
+
+	// SchemaByID returns the schema with the given ID.
+	SchemaByID(id string) (Schema, error)
+}
+
+// KVSchemaRegistry is a SchemaRegistry stored in a JetStream KV bucket.
+//
+// Every version is stored under the "<subject>.<version>" key, which is also the schema ID,
+// and the latest version number under "<subject>.latest". Subjects must be valid KV keys.
+type KVSchemaRegistry struct {
+	kv nats.KeyValue
+
+	cacheLock sync.RWMutex
+	cache     map[string]Schema
+}
+
+const latestSchemaVersionKey = "latest"
+
+// NewKVSchemaRegistry creates a new KVSchemaRegistry using the kv bucket.
+func NewKVSchemaRegistry(kv nats.KeyValue) *KVSchemaRegistry {
+	return &KVSchemaRegistry{
+		kv:    kv,
+		cache: make(map[string]Schema),
+	}
+}
+
+// Register registers a new version of the subject schema and returns it.
+//
+// The definition must be valid for schemaType. When the definition is the same as the latest version,
+// the latest version is returned and no version is created.
+//
+// Versions are stored before the latest version number, so a version stored without updating the latest
+// version number (for example after a crash) is found and the latest version number is repaired.
+func (r *KVSchemaRegistry) Register(subject string, schemaType SchemaType, definition string) (Schema, error) {
+	if _, err := compileSchema(Schema{Subject: subject, Type: schemaType, Definition: definition}); err != nil {
+		return Schema{}, err
+	}
+
+	stored, err := r.Schema(subject, 0)
+	if err != nil && !errors.Is(err, ErrSchemaNotFound) {
+		return Schema{}, err
+	}
+
+	latest := stored
+	for {
+		next, err := r.SchemaByID(schemaID(subject, latest.Version+1))
+		if errors.Is(err, ErrSchemaNotFound) {
+			break
+		} else if err != nil {
+			return Schema{}, err
+		}
+		latest = next
+	}
+
+	if latest.Version > 0 && latest.Type == schemaType && latest.Definition == definition {
+		if latest.Version != stored.Version {
+			if err := r.storeLatestVersion(latest); err != nil {
+				return Schema{}, err
+			}
+		}
+		return latest, nil
+	}
+
+	schema := Schema{
+		ID:         schemaID(subject, latest.Version+1),
+		Subject:    subject,
+		Version:    latest.Version + 1,
+		Type:       schemaType,
+		Definition: definition,
+	}
+
+	value, err := json.Marshal(schema)
+	if err != nil {
+		return Schema{}, errors.Wrap(err, "cannot encode schema")
+	}
+
+	// Create fails when the version was registered concurrently, which is fine for the same definition
+	if _, err := r.kv.Create(schema.ID, value); err != nil {
+		existing, getErr := r.SchemaByID(schema.ID)
+		if getErr != nil || existing.Type != schemaType || existing.Definition != definition {
+			return Schema{}, errors.Wrapf(err, "cannot store schema %s", schema.ID)
+		}
+		schema = existing
+	}
+
+	if err := r.storeLatestVersion(schema); err != nil {
+		return Schema{}, err
+	}
+
+	return schema, nil
+}
+
+func (r *KVSchemaRegistry) storeLatestVersion(schema Schema) error {
+	if _, err := r.kv.PutString(schema.Subject+"."+latestSchemaVersionKey, strconv.Itoa(schema.Version)); err != nil {
+		return errors.Wrapf(err, "cannot store latest version of subject %s", schema.Subject)
+	}
+
+	return nil
+}
+
+// Schema returns the schema of subject in version, or the latest version when version is 0.
+func (r *KVSchemaRegistry) Schema(subject string, version int) (Schema, error) {
+	if version > 0 {
+		return r.SchemaByID(schemaID(subject, version))
+	}
+
+	entry, err := r.kv.Get(subject + "." + latestSchemaVersionKey)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return Schema{}, errors.Wrapf(ErrSchemaNotFound, "subject %s", subject)
+	} else if err != nil {
+		return Schema{}, errors.Wrapf(err, "cannot get latest version of subject %s", subject)
+	}
+
+	latest, err := strconv.Atoi(string(entry.Value()))
+	if err != nil {
+		return Schema{}, errors.Wrapf(err, "invalid latest version of subject %s", subject)
+	}
+
+	return r.SchemaByID(schemaID(subject, latest))
+}
+
+// SchemaByID returns the schema with the given ID.
+func (r *KVSchemaRegistry) SchemaByID(id string) (Schema, error) {
+	r.cacheLock.RLock()
+	schema, ok := r.cache[id]
+	r.cacheLock.RUnlock()
+
+	if ok {
+		return schema, nil
+	}
+
+	if strings.HasSuffix(id, "."+latestSchemaVersionKey) {
+		return Schema{}, errors.Wrapf(ErrSchemaNotFound, "invalid schema ID %s", id)
+	}
+
+	entry, err := r.kv.Get(id)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return Schema{}, errors.Wrapf(ErrSchemaNotFound, "schema %s", id)
+	} else if err != nil {
+		return Schema{}, errors.Wrapf(err, "cannot get schema %s", id)
+	}
+
+	if err := json.Unmarshal(entry.Value(), &schema); err != nil {
+		return Schema{}, errors.Wrapf(err, "cannot decode schema %s", id)
+	}
+	schema.ID = id
+
+	r.cacheLock.Lock()
+	r.cache[id] = schema
+	r.cacheLock.Unlock()
+
+	return schema, nil
+}
+
+func schemaID(subject string, version int) string {
+	return subject + "." + strconv.Itoa(version)
+}
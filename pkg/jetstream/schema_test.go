@@ -0,0 +1,220 @@
+package jetstream_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/linkedin/goavro/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const orderAvroSchema = `{
+	"type": "record",
+	"name": "Order",
+	"fields": [
+		{"name": "id", "type": "string"},
+		{"name": "amount", "type": "long"}
+	]
+}`
+
+const orderJSONSchema = `{
+	"type": "object",
+	"required": ["id", "amount"],
+	"properties": {
+		"id": {"type": "string"},
+		"amount": {"type": "integer"}
+	}
+}`
+
+func newTestSchemaRegistry(t *testing.T) *jetstream.KVSchemaRegistry {
+	registry, _ := newTestSchemaRegistryKV(t)
+	return registry
+}
+
+func newTestSchemaRegistryKV(t *testing.T) (*jetstream.KVSchemaRegistry, nats.KeyValue) {
+	_, js := natsTestConn(t)
+
+	bucket := "schemas_" + watermill.NewShortUUID()
+
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = js.DeleteKeyValue(bucket)
+	})
+
+	return jetstream.NewKVSchemaRegistry(kv), kv
+}
+
+func TestKVSchemaRegistry(t *testing.T) {
+	registry := newTestSchemaRegistry(t)
+
+	_, err := registry.Schema("orders", 0)
+	require.ErrorIs(t, err, jetstream.ErrSchemaNotFound)
+
+	_, err = registry.Register("orders", jetstream.JSONSchema, "{invalid")
+	require.Error(t, err)
+
+	v1, err := registry.Register("orders", jetstream.JSONSchema, orderJSONSchema)
+	require.NoError(t, err)
+	assert.Equal(t, "orders.1", v1.ID)
+	assert.Equal(t, 1, v1.Version)
+
+	same, err := registry.Register("orders", jetstream.JSONSchema, orderJSONSchema)
+	require.NoError(t, err)
+	assert.Equal(t, v1, same)
+
+	v2, err := registry.Register("orders", jetstream.AvroSchema, orderAvroSchema)
+	require.NoError(t, err)
+	assert.Equal(t, 2, v2.Version)
+
+	latest, err := registry.Schema("orders", 0)
+	require.NoError(t, err)
+	assert.Equal(t, v2, latest)
+
+	first, err := registry.Schema("orders", 1)
+	require.NoError(t, err)
+	assert.Equal(t, v1, first)
+
+	byID, err := registry.SchemaByID(v2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, v2, byID)
+
+	_, err = registry.SchemaByID("orders.latest")
+	require.ErrorIs(t, err, jetstream.ErrSchemaNotFound)
+}
+
+func TestKVSchemaRegistry_Repairs_Latest_Version(t *testing.T) {
+	registry, kv := newTestSchemaRegistryKV(t)
+
+	v1, err := registry.Register("orders", jetstream.JSONSchema, orderJSONSchema)
+	require.NoError(t, err)
+
+	// version 2 stored, but the process crashed before updating the latest version
+	_, err = kv.Create("orders.2", []byte(`{"subject":"orders","version":2,"type":"AVRO","definition":`+strconv.Quote(orderAvroSchema)+`}`))
+	require.NoError(t, err)
+
+	latest, err := registry.Schema("orders", 0)
+	require.NoError(t, err)
+	assert.Equal(t, v1, latest)
+
+	v2, err := registry.Register("orders", jetstream.AvroSchema, orderAvroSchema)
+	require.NoError(t, err)
+	assert.Equal(t, 2, v2.Version)
+
+	latest, err = registry.Schema("orders", 0)
+	require.NoError(t, err)
+	assert.Equal(t, v2, latest)
+
+	_, err = kv.Create("orders.3", []byte(`{"subject":"orders","version":3,"type":"JSON","definition":`+strconv.Quote(orderJSONSchema)+`}`))
+	require.NoError(t, err)
+
+	v4, err := registry.Register("orders", jetstream.JSONSchema, `{"type": "object"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 4, v4.Version)
+}
+
+func TestSchemaMarshaler_JSON(t *testing.T) {
+	registry := newTestSchemaRegistry(t)
+
+	schema, err := registry.Register("orders", jetstream.JSONSchema, orderJSONSchema)
+	require.NoError(t, err)
+
+	marshaler := &jetstream.SchemaMarshaler{
+		Marshaler: &jetstream.NATSMarshaler{},
+		Registry:  registry,
+	}
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{"id": "1", "amount": 100}`))
+
+	natsMsg, err := marshaler.Marshal("orders", msg)
+	require.NoError(t, err)
+	assert.Equal(t, schema.ID, natsMsg.Header.Get(jetstream.SchemaIDHdr))
+
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+	assert.Equal(t, msg.UUID, unmarshaledMsg.UUID)
+	assert.Equal(t, msg.Payload, unmarshaledMsg.Payload)
+
+	writerSchema, err := marshaler.WriterSchema(unmarshaledMsg)
+	require.NoError(t, err)
+	assert.Equal(t, schema, writerSchema)
+
+	_, err = marshaler.Marshal("orders", message.NewMessage(watermill.NewUUID(), []byte(`{"id": 1}`)))
+	var schemaErr *jetstream.SchemaError
+	require.ErrorAs(t, err, &schemaErr)
+	assert.Equal(t, schema.ID, schemaErr.SchemaID)
+	assert.True(t, schemaErr.Permanent())
+
+	_, err = marshaler.Marshal("payments", msg)
+	require.ErrorIs(t, err, jetstream.ErrSchemaNotFound)
+}
+
+func TestSchemaMarshaler_Avro(t *testing.T) {
+	registry := newTestSchemaRegistry(t)
+
+	_, err := registry.Register("orders", jetstream.AvroSchema, orderAvroSchema)
+	require.NoError(t, err)
+
+	codec, err := goavro.NewCodec(orderAvroSchema)
+	require.NoError(t, err)
+
+	payload, err := codec.BinaryFromNative(nil, map[string]interface{}{"id": "1", "amount": int64(100)})
+	require.NoError(t, err)
+
+	marshaler := &jetstream.SchemaMarshaler{
+		Marshaler: &jetstream.GobMarshaler{},
+		Registry:  registry,
+		Subject: func(topic string, msg *message.Message) string {
+			return msg.Metadata.Get("subject")
+		},
+	}
+
+	msg := message.NewMessage(watermill.NewUUID(), payload)
+	msg.Metadata.Set("subject", "orders")
+
+	natsMsg, err := marshaler.Marshal("topic", msg)
+	require.NoError(t, err)
+
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+	assert.Equal(t, msg.Payload, unmarshaledMsg.Payload)
+
+	msg.Payload = payload[:len(payload)-1]
+	_, err = marshaler.Marshal("topic", msg)
+	require.ErrorAs(t, err, new(*jetstream.SchemaError))
+}
+
+func TestSchemaMarshaler_Unmarshal_Errors(t *testing.T) {
+	registry := newTestSchemaRegistry(t)
+
+	schema, err := registry.Register("orders", jetstream.JSONSchema, orderJSONSchema)
+	require.NoError(t, err)
+
+	marshaler := &jetstream.SchemaMarshaler{
+		Marshaler: &jetstream.NATSMarshaler{},
+		Registry:  registry,
+	}
+
+	natsMsg, err := (&jetstream.NATSMarshaler{}).Marshal("orders", message.NewMessage(watermill.NewUUID(), []byte(`{}`)))
+	require.NoError(t, err)
+
+	_, err = marshaler.Unmarshal(natsMsg)
+	require.ErrorIs(t, err, jetstream.ErrMissingSchema)
+
+	marshaler.AllowMissingSchema = true
+	_, err = marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+
+	natsMsg.Header.Set(jetstream.SchemaIDHdr, schema.ID)
+	_, err = marshaler.Unmarshal(natsMsg)
+	require.ErrorAs(t, err, new(*jetstream.SchemaError), "payload written without validation should be rejected")
+
+	natsMsg.Header.Set(jetstream.SchemaIDHdr, "orders.42")
+	_, err = marshaler.Unmarshal(natsMsg)
+	require.ErrorIs(t, err, jetstream.ErrSchemaNotFound)
+}
@@ -0,0 +1,425 @@
+package jetstream
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"sort"
+	"strings"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SignatureHdr is the header used by SigningMarshaler to store the signature.
+	SignatureHdr = "_watermill_signature"
+
+	// SignatureKeyIDHdr is the header used by SigningMarshaler to store the ID of the signing key.
+	SignatureKeyIDHdr = "_watermill_signature_key_id"
+
+	// SignatureAlgorithmHdr is the header used by SigningMarshaler to store the signature algorithm.
+	SignatureAlgorithmHdr = "_watermill_signature_alg"
+
+	// SignedHeadersHdr is the header used by SigningMarshaler to store the names of signed headers.
+	SignedHeadersHdr = "_watermill_signed_headers"
+)
+
+// ErrMissingSignature is returned (wrapped in SignatureError) when a message is not signed.
+var ErrMissingSignature = errors.New("message is not signed")
+
+// ErrUntrustedKey is returned (wrapped in SignatureError) when a message is signed with an unknown key.
+// VerifierProvider implementations should wrap it, so such messages are redelivered.
+var ErrUntrustedKey = errors.New("untrusted key")
+
+// SignatureError is returned by SigningMarshaler.Unmarshal when the signature can't be verified.
+//
+// It is a PermanentError, so Subscriber terminates such messages instead of letting them be redelivered.
+// Messages signed with an untrusted key (ErrUntrustedKey) are redelivered instead, the key may become trusted
+// during a key rotation, when publishers get the new key before subscribers.
+type SignatureError struct {
+	KeyID string
+	Err   error
+}
+
+func (e *SignatureError) Error() string {
+	return "invalid signature (key " + e.KeyID + "): " + e.Err.Error()
+}
+
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent returns false for untrusted keys, redelivery will not fix other signature errors.
+func (e *SignatureError) Permanent() bool {
+	return !errors.Is(e.Err, ErrUntrustedKey)
+}
+
+// Signer signs messages.
+type Signer interface {
+	// KeyID returns the ID of the key, stored in the message headers.
+	KeyID() string
+
+	// Algorithm returns the name of the signature algorithm, stored in the message headers.
+	Algorithm() string
+
+	// Sign returns the signature of data.
+	Sign(data []byte) ([]byte, error)
+}
+
+// Verifier verifies message signatures.
+type Verifier interface {
+	// Verify returns an error when signature is not a valid signature of data.
+	Verify(data, signature []byte) error
+}
+
+// VerifierProvider provides the verifier for a key.
+type VerifierProvider interface {
+	// Verifier returns the verifier for the key ID and algorithm, or an error when the key is not trusted.
+	Verifier(keyID, algorithm string) (Verifier, error)
+}
+
+// SigningMarshaler decorates a MarshalerUnmarshaler to sign the data and selected headers of messages.
+//
+// The signature, key ID and algorithm are stored in headers and verified on Unmarshal.
+// Messages with a missing or invalid signature are rejected with SignatureError.
+// Headers which are not signed (except JetStream headers) are removed before unmarshaling,
+// so they can't be injected into the metadata of verified messages.
+type SigningMarshaler struct {
+	// Marshaler is the decorated marshaler.
+	Marshaler MarshalerUnmarshaler
+
+	// Signer is used to sign outgoing messages. It is required for Marshal.
+	Signer Signer
+
+	// Verifiers provides verifiers for incoming messages. It is required for Unmarshal.
+	Verifiers VerifierProvider
+
+	// SignedHeaders are the names of headers included in the signature.
+	// When empty, all headers set by the decorated marshaler are signed.
+	// WatermillUUIDHdr is always signed, so the UUID used for deduplication can't be altered.
+	// On Unmarshal, messages which do not sign all of SignedHeaders are rejected.
+	SignedHeaders []string
+}
+
+// Marshal marshals msg with the decorated marshaler and signs it.
+func (s *SigningMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, error) {
+	if s.Signer == nil {
+		return nil, errors.New("SigningMarshaler.Signer is missing")
+	}
+
+	natsMsg, err := s.Marshaler.Marshal(topic, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if natsMsg.Header == nil {
+		natsMsg.Header = make(nats.Header)
+	}
+
+	var signedHeaders []string
+	if len(s.SignedHeaders) == 0 {
+		for k := range natsMsg.Header {
+			signedHeaders = append(signedHeaders, k)
+		}
+	} else {
+		signedHeaders = append(signedHeaders, s.SignedHeaders...)
+		if _, ok := natsMsg.Header[WatermillUUIDHdr]; ok {
+			signedHeaders = append(signedHeaders, WatermillUUIDHdr)
+		}
+	}
+	signedHeaders = normalizeSignedHeaders(signedHeaders)
+
+	keyID := s.Signer.KeyID()
+	algorithm := s.Signer.Algorithm()
+
+	signature, err := s.Signer.Sign(signingInput(keyID, algorithm, signedHeaders, natsMsg))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot sign message")
+	}
+
+	natsMsg.Header.Set(SignatureKeyIDHdr, keyID)
+	natsMsg.Header.Set(SignatureAlgorithmHdr, algorithm)
+	natsMsg.Header.Set(SignedHeadersHdr, strings.Join(signedHeaders, ","))
+	natsMsg.Header.Set(SignatureHdr, base64.StdEncoding.EncodeToString(signature))
+
+	return natsMsg, nil
+}
+
+// Unmarshal verifies the signature and unmarshals the message with the decorated unmarshaler.
+func (s *SigningMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	if s.Verifiers == nil {
+		return nil, errors.New("SigningMarshaler.Verifiers is missing")
+	}
+
+	signedHeaders, err := s.verify(natsMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Marshaler.Unmarshal(withSignedHeaders(natsMsg, signedHeaders))
+}
+
+// withSignedHeaders returns a copy of natsMsg with only signed and reserved headers.
+func withSignedHeaders(natsMsg *nats.Msg, signedHeaders []string) *nats.Msg {
+	verified := withData(natsMsg, natsMsg.Data)
+
+	for k := range verified.Header {
+		if !isReservedHeader(k) && !containsString(signedHeaders, k) {
+			verified.Header.Del(k)
+		}
+	}
+
+	return verified
+}
+
+func (s *SigningMarshaler) verify(natsMsg *nats.Msg) ([]string, error) {
+	keyID := natsMsg.Header.Get(SignatureKeyIDHdr)
+	algorithm := natsMsg.Header.Get(SignatureAlgorithmHdr)
+	encodedSignature := natsMsg.Header.Get(SignatureHdr)
+
+	if keyID == "" || encodedSignature == "" {
+		return nil, &SignatureError{KeyID: keyID, Err: ErrMissingSignature}
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, &SignatureError{KeyID: keyID, Err: errors.Wrap(err, "cannot decode signature")}
+	}
+
+	var signedHeaders []string
+	if v := natsMsg.Header.Get(SignedHeadersHdr); v != "" {
+		signedHeaders = strings.Split(v, ",")
+	}
+
+	for _, required := range s.SignedHeaders {
+		if !containsString(signedHeaders, required) {
+			return nil, &SignatureError{KeyID: keyID, Err: errors.Errorf("header %q is not signed", required)}
+		}
+	}
+
+	if _, ok := natsMsg.Header[WatermillUUIDHdr]; ok && !containsString(signedHeaders, WatermillUUIDHdr) {
+		return nil, &SignatureError{KeyID: keyID, Err: errors.Errorf("header %q is not signed", WatermillUUIDHdr)}
+	}
+
+	verifier, err := s.Verifiers.Verifier(keyID, algorithm)
+	if err != nil {
+		return nil, &SignatureError{KeyID: keyID, Err: err}
+	}
+
+	if err := verifier.Verify(signingInput(keyID, algorithm, signedHeaders, natsMsg), signature); err != nil {
+		return nil, &SignatureError{KeyID: keyID, Err: err}
+	}
+
+	return signedHeaders, nil
+}
+
+// signingInput builds the signed content, all variable length fields are length prefixed to avoid ambiguity.
+func signingInput(keyID, algorithm string, signedHeaders []string, natsMsg *nats.Msg) []byte {
+	buf := new(bytes.Buffer)
+
+	writeLength := func(length int) {
+		var b [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(b[:], uint64(length))
+		buf.Write(b[:n])
+	}
+	writeField := func(b []byte) {
+		writeLength(len(b))
+		buf.Write(b)
+	}
+
+	writeField([]byte(keyID))
+	writeField([]byte(algorithm))
+
+	for _, k := range signedHeaders {
+		writeField([]byte(k))
+
+		values := natsMsg.Header.Values(k)
+		writeLength(len(values))
+		for _, v := range values {
+			writeField([]byte(v))
+		}
+	}
+
+	writeField(natsMsg.Data)
+
+	return buf.Bytes()
+}
+
+func normalizeSignedHeaders(headers []string) []string {
+	normalized := make([]string, 0, len(headers))
+
+	for _, k := range headers {
+		switch k {
+		case SignatureHdr, SignatureKeyIDHdr, SignatureAlgorithmHdr, SignedHeadersHdr:
+			continue
+		}
+		if !containsString(normalized, k) {
+			normalized = append(normalized, k)
+		}
+	}
+
+	sort.Strings(normalized)
+
+	return normalized
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// HMACSigner signs and verifies messages with HMAC-SHA256 and a shared secret.
+type HMACSigner struct {
+	ID     string
+	Secret []byte
+}
+
+// KeyID returns the ID of the secret.
+func (h HMACSigner) KeyID() string {
+	return h.ID
+}
+
+// Algorithm returns "hmac-sha256".
+func (h HMACSigner) Algorithm() string {
+	return "hmac-sha256"
+}
+
+// Sign returns the HMAC of data.
+func (h HMACSigner) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, h.Secret)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// Verify checks the HMAC of data.
+func (h HMACSigner) Verify(data, signature []byte) error {
+	expected, _ := h.Sign(data)
+	if !hmac.Equal(expected, signature) {
+		return errors.New("hmac mismatch")
+	}
+	return nil
+}
+
+// Ed25519Signer signs messages with an Ed25519 private key.
+type Ed25519Signer struct {
+	ID         string
+	PrivateKey ed25519.PrivateKey
+}
+
+// KeyID returns the ID of the key.
+func (e Ed25519Signer) KeyID() string {
+	return e.ID
+}
+
+// Algorithm returns "ed25519".
+func (e Ed25519Signer) Algorithm() string {
+	return "ed25519"
+}
+
+// Sign returns the Ed25519 signature of data.
+func (e Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(e.PrivateKey, data), nil
+}
+
+// Verify checks the signature with the public part of the private key.
+func (e Ed25519Signer) Verify(data, signature []byte) error {
+	publicKey, _ := e.PrivateKey.Public().(ed25519.PublicKey)
+	return Ed25519Verifier{PublicKey: publicKey}.Verify(data, signature)
+}
+
+// Ed25519Verifier verifies messages with an Ed25519 public key.
+type Ed25519Verifier struct {
+	PublicKey ed25519.PublicKey
+}
+
+// Verify checks the Ed25519 signature of data.
+func (e Ed25519Verifier) Verify(data, signature []byte) error {
+	if !ed25519.Verify(e.PublicKey, data, signature) {
+		return errors.New("ed25519 signature mismatch")
+	}
+	return nil
+}
+
+// NKeySigner signs messages with a NATS nkey. The key ID is the public nkey.
+type NKeySigner struct {
+	KeyPair nkeys.KeyPair
+}
+
+// NewNKeySigner creates a NKeySigner from a nkey seed.
+func NewNKeySigner(seed []byte) (NKeySigner, error) {
+	kp, err := nkeys.FromSeed(seed)
+	if err != nil {
+		return NKeySigner{}, errors.Wrap(err, "invalid nkey seed")
+	}
+
+	return NKeySigner{KeyPair: kp}, nil
+}
+
+// KeyID returns the public nkey.
+func (n NKeySigner) KeyID() string {
+	publicKey, _ := n.KeyPair.PublicKey()
+	return publicKey
+}
+
+// Algorithm returns "nkey".
+func (n NKeySigner) Algorithm() string {
+	return "nkey"
+}
+
+// Sign returns the nkey signature of data.
+func (n NKeySigner) Sign(data []byte) ([]byte, error) {
+	return n.KeyPair.Sign(data)
+}
+
+// Verify checks the nkey signature of data.
+func (n NKeySigner) Verify(data, signature []byte) error {
+	return n.KeyPair.Verify(data, signature)
+}
+
+// Verifiers is a VerifierProvider using a static set of verifiers, keyed by key ID.
+type Verifiers map[string]Verifier
+
+// Verifier returns the verifier registered for keyID.
+func (v Verifiers) Verifier(keyID, algorithm string) (Verifier, error) {
+	verifier, ok := v[keyID]
+	if !ok {
+		return nil, errors.Wrapf(ErrUntrustedKey, "key %q", keyID)
+	}
+
+	if signer, ok := verifier.(Signer); ok && signer.Algorithm() != algorithm {
+		return nil, errors.Errorf("unexpected algorithm %q for key %q", algorithm, keyID)
+	}
+
+	return verifier, nil
+}
+
+// TrustedNKeys is a VerifierProvider accepting messages signed by any of the listed public nkeys.
+type TrustedNKeys []string
+
+// Verifier returns a verifier for keyID, when it is one of the trusted public nkeys.
+func (t TrustedNKeys) Verifier(keyID, algorithm string) (Verifier, error) {
+	if algorithm != "nkey" {
+		return nil, errors.Errorf("unexpected algorithm %q for nkey", algorithm)
+	}
+
+	if !containsString(t, keyID) {
+		return nil, errors.Wrapf(ErrUntrustedKey, "nkey %q", keyID)
+	}
+
+	kp, err := nkeys.FromPublicKey(keyID)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public nkey")
+	}
+
+	return NKeySigner{KeyPair: kp}, nil
+}
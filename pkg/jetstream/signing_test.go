@@ -0,0 +1,170 @@
+package jetstream_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testHMACSigner = jetstream.HMACSigner{ID: "hmac-1", Secret: []byte("secret")}
+
+func TestSigningMarshaler(t *testing.T) {
+	_, ed25519PrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ed25519Signer := jetstream.Ed25519Signer{ID: "ed-1", PrivateKey: ed25519PrivateKey}
+
+	nkey, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	nkeySigner := jetstream.NKeySigner{KeyPair: nkey}
+	nkeyPublic, err := nkey.PublicKey()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		signer    jetstream.Signer
+		verifiers jetstream.VerifierProvider
+	}{
+		{"hmac", testHMACSigner, jetstream.Verifiers{"hmac-1": testHMACSigner}},
+		{"ed25519", ed25519Signer, jetstream.Verifiers{"ed-1": jetstream.Ed25519Verifier{PublicKey: ed25519PrivateKey.Public().(ed25519.PublicKey)}}},
+		{"nkey", nkeySigner, jetstream.TrustedNKeys{nkeyPublic}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			marshaler := &jetstream.SigningMarshaler{
+				Marshaler: &jetstream.NATSMarshaler{},
+				Signer:    tc.signer,
+				Verifiers: tc.verifiers,
+			}
+
+			msg := sampleMessage(100)
+
+			natsMsg, err := marshaler.Marshal("topic", msg)
+			require.NoError(t, err)
+			assert.Equal(t, tc.signer.KeyID(), natsMsg.Header.Get(jetstream.SignatureKeyIDHdr))
+
+			unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+			require.NoError(t, err)
+			assert.True(t, msg.Equals(unmarshaledMsg))
+
+			// headers added after signing (for example by tracing) do not break the signature,
+			// but they are not trusted, so they don't reach the metadata
+			natsMsg.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+			unmarshaledMsg, err = marshaler.Unmarshal(natsMsg)
+			require.NoError(t, err)
+			assert.True(t, msg.Equals(unmarshaledMsg))
+			assert.Empty(t, unmarshaledMsg.Metadata.Get("traceparent"))
+
+			tamperedPayload := withTamperedMessage(natsMsg, func(m *nats.Msg) { m.Data = []byte("tampered") })
+			assertSignatureError(t, marshaler, tamperedPayload)
+
+			for k := range msg.Metadata {
+				tamperedHeader := withTamperedMessage(natsMsg, func(m *nats.Msg) { m.Header.Set(k, "tampered") })
+				assertSignatureError(t, marshaler, tamperedHeader)
+				break
+			}
+		})
+	}
+}
+
+func TestSigningMarshaler_Untrusted(t *testing.T) {
+	marshaler := &jetstream.SigningMarshaler{
+		Marshaler: &jetstream.NATSMarshaler{},
+		Signer:    jetstream.HMACSigner{ID: "hmac-2", Secret: []byte("secret")},
+		Verifiers: jetstream.Verifiers{"hmac-1": testHMACSigner},
+	}
+
+	natsMsg, err := marshaler.Marshal("topic", sampleMessage(100))
+	require.NoError(t, err)
+
+	// the key may become trusted during a key rotation, so the message is redelivered
+	_, err = marshaler.Unmarshal(natsMsg)
+	var signatureErr *jetstream.SignatureError
+	require.ErrorAs(t, err, &signatureErr)
+	assert.ErrorIs(t, err, jetstream.ErrUntrustedKey)
+	assert.False(t, signatureErr.Permanent())
+
+	unsigned, err := (&jetstream.NATSMarshaler{}).Marshal("topic", sampleMessage(100))
+	require.NoError(t, err)
+	err = assertSignatureError(t, marshaler, unsigned)
+	assert.ErrorIs(t, err, jetstream.ErrMissingSignature)
+}
+
+func TestSigningMarshaler_Required_Headers(t *testing.T) {
+	signingMarshaler := &jetstream.SigningMarshaler{
+		Marshaler:     &jetstream.NATSMarshaler{},
+		Signer:        testHMACSigner,
+		SignedHeaders: []string{jetstream.WatermillUUIDHdr},
+	}
+	verifyingMarshaler := &jetstream.SigningMarshaler{
+		Marshaler:     &jetstream.NATSMarshaler{},
+		Verifiers:     jetstream.Verifiers{"hmac-1": testHMACSigner},
+		SignedHeaders: []string{jetstream.WatermillUUIDHdr, "tenant"},
+	}
+
+	natsMsg, err := signingMarshaler.Marshal("topic", sampleMessage(100))
+	require.NoError(t, err)
+	assertSignatureError(t, verifyingMarshaler, natsMsg)
+
+	verifyingMarshaler.SignedHeaders = []string{jetstream.WatermillUUIDHdr}
+
+	injected := withTamperedMessage(natsMsg, func(m *nats.Msg) { m.Header.Set("tenant", "injected") })
+	msg, err := verifyingMarshaler.Unmarshal(injected)
+	require.NoError(t, err)
+	assert.Empty(t, msg.Metadata, "unsigned headers should be removed")
+}
+
+func TestSigningMarshaler_UUID_Always_Signed(t *testing.T) {
+	marshaler := &jetstream.SigningMarshaler{
+		Marshaler:     &jetstream.NATSMarshaler{},
+		Signer:        testHMACSigner,
+		Verifiers:     jetstream.Verifiers{"hmac-1": testHMACSigner},
+		SignedHeaders: []string{"tenant"},
+	}
+
+	msg := sampleMessage(100)
+	msg.Metadata.Set("tenant", "acme")
+
+	natsMsg, err := marshaler.Marshal("topic", msg)
+	require.NoError(t, err)
+	assert.Equal(t, "_watermill_message_uuid,tenant", natsMsg.Header.Get(jetstream.SignedHeadersHdr))
+
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+	assert.Equal(t, msg.UUID, unmarshaledMsg.UUID)
+
+	assertSignatureError(t, marshaler, withTamperedMessage(natsMsg, func(m *nats.Msg) {
+		m.Header.Set(jetstream.WatermillUUIDHdr, watermill.NewUUID())
+	}))
+	assertSignatureError(t, marshaler, withTamperedMessage(natsMsg, func(m *nats.Msg) {
+		m.Header.Set(jetstream.SignedHeadersHdr, "tenant")
+	}))
+}
+
+func withTamperedMessage(natsMsg *nats.Msg, tamper func(m *nats.Msg)) *nats.Msg {
+	tampered := nats.NewMsg(natsMsg.Subject)
+	tampered.Data = natsMsg.Data
+	for k, v := range natsMsg.Header {
+		tampered.Header[k] = v
+	}
+	tamper(tampered)
+	return tampered
+}
+
+func assertSignatureError(t *testing.T, unmarshaler jetstream.Unmarshaler, natsMsg *nats.Msg) error {
+	_, err := unmarshaler.Unmarshal(natsMsg)
+	require.Error(t, err)
+
+	var signatureErr *jetstream.SignatureError
+	require.ErrorAs(t, err, &signatureErr)
+	assert.True(t, signatureErr.Permanent())
+
+	return err
+}
@@ -0,0 +1,89 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriber_SubscribeWithConfig(t *testing.T) {
+	natsURL, js, pub := natsTestSetup(t)
+
+	ephemeralTopic := "ephemeral_" + watermill.NewShortUUID()
+	durableTopic := "durable_" + watermill.NewShortUUID()
+	resolvedTopic := "resolved_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(ephemeralTopic)
+		_ = js.DeleteStream(durableTopic)
+		_ = js.DeleteStream(resolvedTopic)
+	}()
+
+	gobPub, err := jetstream.NewPublisher(jetstream.PublisherConfig{
+		URL:           natsURL,
+		Marshaler:     &jetstream.GobMarshaler{},
+		AutoProvision: true,
+	}, nil)
+	require.NoError(t, err)
+	defer gobPub.Close()
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsURL,
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		TopicConfigOverride: func(topic string, config *jetstream.SubscriberSubscriptionConfig) {
+			if topic == resolvedTopic {
+				config.DurableName = "resolved"
+			}
+		},
+	}, watermill.NewStdLogger(false, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ephemeralMessages, err := sub.Subscribe(ctx, ephemeralTopic)
+	require.NoError(t, err)
+
+	durableMessages, err := sub.SubscribeWithConfig(ctx, durableTopic, func(topic string, config *jetstream.SubscriberSubscriptionConfig) {
+		config.Unmarshaler = &jetstream.GobMarshaler{}
+		config.DurableName = "group"
+		config.QueueGroup = "group"
+		config.SubscribersCount = 2
+	})
+	require.NoError(t, err)
+
+	resolvedMessages, err := sub.Subscribe(ctx, resolvedTopic)
+	require.NoError(t, err)
+
+	ephemeralMsg := message.NewMessage(watermill.NewUUID(), []byte("ephemeral"))
+	require.NoError(t, pub.Publish(ephemeralTopic, ephemeralMsg))
+	requireMessage(t, ctx, ephemeralMessages, ephemeralMsg)
+
+	durableMsg := message.NewMessage(watermill.NewUUID(), []byte("durable"))
+	require.NoError(t, gobPub.Publish(durableTopic, durableMsg))
+	requireMessage(t, ctx, durableMessages, durableMsg)
+
+	resolvedMsg := message.NewMessage(watermill.NewUUID(), []byte("resolved"))
+	require.NoError(t, pub.Publish(resolvedTopic, resolvedMsg))
+	requireMessage(t, ctx, resolvedMessages, resolvedMsg)
+
+	info, err := js.ConsumerInfo(durableTopic, "group")
+	require.NoError(t, err)
+	assert.Equal(t, "group", info.Config.DeliverGroup)
+
+	_, err = js.ConsumerInfo(resolvedTopic, "resolved")
+	require.NoError(t, err)
+
+	_, err = sub.SubscribeWithConfig(ctx, durableTopic, func(topic string, config *jetstream.SubscriberSubscriptionConfig) {
+		config.QueueGroup = ""
+		config.SubscribersCount = 2
+	})
+	require.Error(t, err, "overrides should be validated")
+}
@@ -43,6 +43,20 @@ type SubscriberConfig struct {
 	// the last acknowledged message for that ClientID + DurableName.
 	DurableName string
 
+	// DurableNameCalculator calculates the durable consumer name of each topic from DurableName.
+	// DurableName is used for all topics when it is nil, which works only when topics are stored in separate streams.
+	// See TopicDurableNameCalculator.
+	DurableNameCalculator DurableNameCalculator
+
+	// InactiveThreshold is how long the server keeps a consumer without subscriptions before removing it,
+	// the server default is used when zero. Durable consumers are removed only by NATS server 2.9 and newer.
+	InactiveThreshold time.Duration
+
+	// DeleteDurableConsumers deletes durable consumers when subscriptions end, on Close or when the Subscribe context
+	// is cancelled. Ephemeral consumers are always deleted. Durable consumers shared with other subscribers
+	// (with QueueGroup) are deleted for all of them.
+	DeleteDurableConsumers bool
+
 	// SubscribersCount determines how many concurrent subscribers should be started.
 	SubscribersCount int
 
@@ -83,8 +97,71 @@ type SubscriberConfig struct {
 	// NakDelay sets duration after which the NACKed message will be resent.
 	// By default, it's NACKed without delay.
 	NakDelay Delay
+
+	// Metrics is used to record the consumption of messages. Nothing is recorded when it is nil.
+	Metrics Metrics
+
+	// Tracer is used to extract trace context from NATS headers and to create consumer spans.
+	// Nothing is traced when it is nil.
+	Tracer Tracer
+
+	// ClaimCheck configures handling of claim-checked messages, only DeleteOnAck is used by the subscriber.
+	// Claim-checked messages are fetched from their bucket even when it is nil.
+	ClaimCheck *ClaimCheckConfig
+
+	// Workers is the number of workers processing messages of each subscription concurrently (defaults to 1).
+	// When it is greater than 1, messages are routed to workers by PartitionKey, so messages with the same key
+	// are processed in order. Messages are buffered by workers, AckWaitTimeout should allow for that.
+	// It can't be combined with SubscribersCount greater than 1, which would spread messages with the same key
+	// across the worker pools of different subscriptions.
+	Workers int
+
+	// PartitionKey returns the key used to route messages to workers, it is required when Workers is greater than 1.
+	// See SubjectTokenPartitionKey and MetadataPartitionKey.
+	PartitionKey PartitionKeyFunc
+
+	// MaxAckPending is the maximum number of messages delivered by the consumer and not acked yet.
+	// The same number of messages is processed at once by each Subscribe call, so messages are not
+	// pushed faster than they are handled. The server default is used when it is zero.
+	MaxAckPending int
+
+	// PendingMsgsLimit and PendingBytesLimit limit messages buffered by the client for a subscription,
+	// the nats.go defaults are used when zero, -1 disables a limit.
+	// Messages over the limits are dropped and a slow consumer error is logged.
+	PendingMsgsLimit  int
+	PendingBytesLimit int
+
+	// IdleHeartbeat enables idle heartbeats and flow control of the push consumer, with the given heartbeat interval.
+	// It is not supported with QueueGroup.
+	IdleHeartbeat time.Duration
+
+	// CircuitBreaker pauses consuming a topic while it returns true, it requires DurableName or QueueGroup.
+	// Paused topics are resumed when it returns false, checked every CircuitBreakerInterval,
+	// unless they were paused explicitly with Subscriber.Pause.
+	CircuitBreaker CircuitBreaker
+
+	// CircuitBreakerInterval is how often CircuitBreaker is checked while a topic is paused by it (defaults to 5s).
+	CircuitBreakerInterval time.Duration
+
+	// RateLimit limits the rate at which messages of each topic are sent to the output channel.
+	// Messages are not limited when it is nil.
+	RateLimit *RateLimit
+
+	// TopicRateLimits overrides RateLimit for the given topics.
+	TopicRateLimits map[string]RateLimit
+
+	// ConsumerRateLimit is the server-side rate limit of the push consumer in bits per second,
+	// it is set when the consumer is created. It is not limited when zero.
+	ConsumerRateLimit uint64
+
+	// TopicConfigOverride modifies the subscription configuration of individual topics, for example to use
+	// a different DurableName, QueueGroup or Unmarshaler. JetstreamOptions can't be overridden.
+	TopicConfigOverride SubscriptionConfigOverride
 }
 
+// SubscriptionConfigOverride modifies the subscription configuration of topic.
+type SubscriptionConfigOverride func(topic string, config *SubscriberSubscriptionConfig)
+
 // SubscriberSubscriptionConfig is the configurationz
 type SubscriberSubscriptionConfig struct {
 	// Unmarshaler is an unmarshaler used to unmarshaling messages from NATS format to Watermill format.
@@ -115,6 +192,20 @@ type SubscriberSubscriptionConfig struct {
 	// the last acknowledged message for that ClientID + DurableName.
 	DurableName string
 
+	// DurableNameCalculator calculates the durable consumer name of each topic from DurableName.
+	// DurableName is used for all topics when it is nil, which works only when topics are stored in separate streams.
+	// See TopicDurableNameCalculator.
+	DurableNameCalculator DurableNameCalculator
+
+	// InactiveThreshold is how long the server keeps a consumer without subscriptions before removing it,
+	// the server default is used when zero. Durable consumers are removed only by NATS server 2.9 and newer.
+	InactiveThreshold time.Duration
+
+	// DeleteDurableConsumers deletes durable consumers when subscriptions end, on Close or when the Subscribe context
+	// is cancelled. Ephemeral consumers are always deleted. Durable consumers shared with other subscribers
+	// (with QueueGroup) are deleted for all of them.
+	DeleteDurableConsumers bool
+
 	// SubscribersCount determines wow much concurrent subscribers should be started.
 	SubscribersCount int
 
@@ -147,24 +238,102 @@ type SubscriberSubscriptionConfig struct {
 	// NakDelay sets duration after which the NACKed message will be resent.
 	// By default, it's NACKed without delay.
 	NakDelay Delay
+
+	// Metrics is used to record the consumption of messages. Nothing is recorded when it is nil.
+	Metrics Metrics
+
+	// Tracer is used to extract trace context from NATS headers and to create consumer spans.
+	// Nothing is traced when it is nil.
+	Tracer Tracer
+
+	// ClaimCheck configures handling of claim-checked messages, only DeleteOnAck is used by the subscriber.
+	// Claim-checked messages are fetched from their bucket even when it is nil.
+	ClaimCheck *ClaimCheckConfig
+
+	// Workers is the number of workers processing messages of each subscription concurrently (defaults to 1).
+	// When it is greater than 1, messages are routed to workers by PartitionKey, so messages with the same key
+	// are processed in order. Messages are buffered by workers, AckWaitTimeout should allow for that.
+	// It can't be combined with SubscribersCount greater than 1, which would spread messages with the same key
+	// across the worker pools of different subscriptions.
+	Workers int
+
+	// PartitionKey returns the key used to route messages to workers, it is required when Workers is greater than 1.
+	// See SubjectTokenPartitionKey and MetadataPartitionKey.
+	PartitionKey PartitionKeyFunc
+
+	// MaxAckPending is the maximum number of messages delivered by the consumer and not acked yet.
+	// The same number of messages is processed at once by each Subscribe call, so messages are not
+	// pushed faster than they are handled. The server default is used when it is zero.
+	MaxAckPending int
+
+	// PendingMsgsLimit and PendingBytesLimit limit messages buffered by the client for a subscription,
+	// the nats.go defaults are used when zero, -1 disables a limit.
+	// Messages over the limits are dropped and a slow consumer error is logged.
+	PendingMsgsLimit  int
+	PendingBytesLimit int
+
+	// IdleHeartbeat enables idle heartbeats and flow control of the push consumer, with the given heartbeat interval.
+	// It is not supported with QueueGroup.
+	IdleHeartbeat time.Duration
+
+	// CircuitBreaker pauses consuming a topic while it returns true, it requires DurableName or QueueGroup.
+	// Paused topics are resumed when it returns false, checked every CircuitBreakerInterval,
+	// unless they were paused explicitly with Subscriber.Pause.
+	CircuitBreaker CircuitBreaker
+
+	// CircuitBreakerInterval is how often CircuitBreaker is checked while a topic is paused by it (defaults to 5s).
+	CircuitBreakerInterval time.Duration
+
+	// RateLimit limits the rate at which messages of each topic are sent to the output channel.
+	// Messages are not limited when it is nil.
+	RateLimit *RateLimit
+
+	// TopicRateLimits overrides RateLimit for the given topics.
+	TopicRateLimits map[string]RateLimit
+
+	// ConsumerRateLimit is the server-side rate limit of the push consumer in bits per second,
+	// it is set when the consumer is created. It is not limited when zero.
+	ConsumerRateLimit uint64
+
+	// TopicConfigOverride modifies the subscription configuration of individual topics, for example to use
+	// a different DurableName, QueueGroup or Unmarshaler. JetstreamOptions can't be overridden.
+	TopicConfigOverride SubscriptionConfigOverride
 }
 
 // GetSubscriberSubscriptionConfig gets the configuration subset needed for individual subscribe calls once a connection has been established
 func (c *SubscriberConfig) GetSubscriberSubscriptionConfig() SubscriberSubscriptionConfig {
 	return SubscriberSubscriptionConfig{
-		Unmarshaler:       c.Unmarshaler,
-		QueueGroup:        c.QueueGroup,
-		DurableName:       c.DurableName,
-		SubscribersCount:  c.SubscribersCount,
-		AckWaitTimeout:    c.AckWaitTimeout,
-		CloseTimeout:      c.CloseTimeout,
-		SubscribeTimeout:  c.SubscribeTimeout,
-		SubscribeOptions:  c.SubscribeOptions,
-		SubjectCalculator: c.SubjectCalculator,
-		AutoProvision:     c.AutoProvision,
-		JetstreamOptions:  c.JetstreamOptions,
-		AckSync:           c.AckSync,
-		NakDelay:          c.NakDelay,
+		Unmarshaler:            c.Unmarshaler,
+		QueueGroup:             c.QueueGroup,
+		DurableName:            c.DurableName,
+		DurableNameCalculator:  c.DurableNameCalculator,
+		InactiveThreshold:      c.InactiveThreshold,
+		DeleteDurableConsumers: c.DeleteDurableConsumers,
+		SubscribersCount:       c.SubscribersCount,
+		AckWaitTimeout:         c.AckWaitTimeout,
+		CloseTimeout:           c.CloseTimeout,
+		SubscribeTimeout:       c.SubscribeTimeout,
+		SubscribeOptions:       c.SubscribeOptions,
+		SubjectCalculator:      c.SubjectCalculator,
+		AutoProvision:          c.AutoProvision,
+		JetstreamOptions:       c.JetstreamOptions,
+		AckSync:                c.AckSync,
+		NakDelay:               c.NakDelay,
+		Metrics:                c.Metrics,
+		Tracer:                 c.Tracer,
+		ClaimCheck:             c.ClaimCheck,
+		Workers:                c.Workers,
+		PartitionKey:           c.PartitionKey,
+		MaxAckPending:          c.MaxAckPending,
+		PendingMsgsLimit:       c.PendingMsgsLimit,
+		PendingBytesLimit:      c.PendingBytesLimit,
+		IdleHeartbeat:          c.IdleHeartbeat,
+		CircuitBreaker:         c.CircuitBreaker,
+		CircuitBreakerInterval: c.CircuitBreakerInterval,
+		RateLimit:              c.RateLimit,
+		TopicRateLimits:        c.TopicRateLimits,
+		ConsumerRateLimit:      c.ConsumerRateLimit,
+		TopicConfigOverride:    c.TopicConfigOverride,
 	}
 }
 
@@ -172,6 +341,12 @@ func (c *SubscriberSubscriptionConfig) setDefaults() {
 	if c.SubscribersCount <= 0 {
 		c.SubscribersCount = 1
 	}
+	if c.Workers <= 0 {
+		c.Workers = 1
+	}
+	if c.CircuitBreakerInterval <= 0 {
+		c.CircuitBreakerInterval = time.Second * 5
+	}
 	if c.CloseTimeout <= 0 {
 		c.CloseTimeout = time.Second * 30
 	}
@@ -205,6 +380,36 @@ func (c *SubscriberSubscriptionConfig) Validate() error {
 		return errors.New("SubscriberSubscriptionConfig.SubjectCalculator is required.")
 	}
 
+	if c.Workers > 1 && c.PartitionKey == nil {
+		return errors.New("SubscriberConfig.PartitionKey is required when SubscriberConfig.Workers is greater than 1")
+	}
+
+	if c.Workers > 1 && c.SubscribersCount > 1 {
+		return errors.New(
+			"SubscriberConfig.Workers greater than 1 is not supported with SubscriberConfig.SubscribersCount " +
+				"greater than 1, messages with the same partition key would not be processed in order",
+		)
+	}
+
+	if c.IdleHeartbeat > 0 && c.QueueGroup != "" {
+		return errors.New("SubscriberConfig.IdleHeartbeat is not supported with SubscriberConfig.QueueGroup")
+	}
+
+	if c.ClaimCheck != nil && c.ClaimCheck.DeleteOnAck && !c.AckSync {
+		return errors.New("SubscriberConfig.ClaimCheck.DeleteOnAck requires SubscriberConfig.AckSync")
+	}
+
+	if c.RateLimit != nil {
+		if err := c.RateLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	for topic, limit := range c.TopicRateLimits {
+		if err := limit.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid rate limit of topic %s", topic)
+		}
+	}
+
 	return nil
 }
 
@@ -221,8 +426,25 @@ type Subscriber struct {
 	closing chan struct{}
 
 	outputsWg        sync.WaitGroup
-	js               nats.JetStream
+	js               nats.JetStreamContext
 	topicInterpreter *topicInterpreter
+	claimChecks      *claimChecks
+	rateLimiters     *rateLimiters
+
+	activeSubsLock sync.RWMutex
+	activeSubs     map[*nats.Subscription]*activeSubscription
+
+	topicSubsLock sync.Mutex
+	topicSubs     map[string][]*topicSubscription
+}
+
+// activeSubscription keeps track of a running nats subscription.
+type activeSubscription struct {
+	topic string
+	sub   *nats.Subscription
+
+	lock          sync.Mutex
+	lastNotActive time.Time
 }
 
 // NewSubscriber creates a new Subscriber.
@@ -252,26 +474,101 @@ func NewSubscriberWithNatsConn(conn *nats.Conn, config SubscriberSubscriptionCon
 		return nil, err
 	}
 
-	return &Subscriber{
+	s := &Subscriber{
 		conn:             conn,
 		logger:           logger,
 		config:           config,
 		closing:          make(chan struct{}),
 		js:               js,
 		topicInterpreter: newTopicInterpreter(js, config.SubjectCalculator),
-	}, nil
+		claimChecks:      newClaimChecks(js),
+		rateLimiters:     &rateLimiters{},
+		activeSubs:       make(map[*nats.Subscription]*activeSubscription),
+		topicSubs:        make(map[string][]*topicSubscription),
+	}
+
+	s.chainErrorHandler()
+
+	return s, nil
+}
+
+// chainErrorHandler registers an async error handler on the connection, preserving the one set by the user.
+func (s *Subscriber) chainErrorHandler() {
+	previous := s.conn.Opts.AsyncErrorCB
+
+	s.conn.SetErrorHandler(func(conn *nats.Conn, sub *nats.Subscription, err error) {
+		s.handleAsyncError(sub, err)
+
+		if previous != nil {
+			previous(conn, sub, err)
+		}
+	})
+}
+
+func (s *Subscriber) handleAsyncError(sub *nats.Subscription, err error) {
+	if sub == nil {
+		return
+	}
+
+	s.activeSubsLock.RLock()
+	active, ok := s.activeSubs[sub]
+	s.activeSubsLock.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	logFields := watermill.LogFields{"topic": active.topic}
+
+	if errors.Is(err, nats.ErrSlowConsumer) {
+		s.logSlowConsumer(sub, err, logFields)
+		return
+	}
+
+	if errors.Is(err, nats.ErrConsumerNotActive) {
+		active.lock.Lock()
+		active.lastNotActive = time.Now()
+		active.lock.Unlock()
+
+		s.logger.Info("Consumer heartbeats missed", logFields)
+		return
+	}
+
+	s.logger.Error("Async subscription error", err, logFields)
 }
 
 // Subscribe subscribes messages from JetStream.
 func (s *Subscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	return s.SubscribeWithConfig(ctx, topic, nil)
+}
+
+// SubscribeWithConfig subscribes messages from JetStream, with the subscription configuration modified by override.
+//
+// override is applied after SubscriberConfig.TopicConfigOverride, it may be nil.
+// The connection of the subscriber is reused, so JetstreamOptions can't be overridden.
+func (s *Subscriber) SubscribeWithConfig(
+	ctx context.Context,
+	topic string,
+	override SubscriptionConfigOverride,
+) (<-chan *message.Message, error) {
+	config, err := s.topicConfig(topic, override)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid subscription config of topic %s", topic)
+	}
+
 	output := make(chan *message.Message)
 
 	s.outputsWg.Add(1)
 	outputWg := &sync.WaitGroup{}
+	inFlight := newInFlightLimiter(config.MaxAckPending)
 
-	for i := 0; i < s.config.SubscribersCount; i++ {
-		outputWg.Add(1)
+	ts := &topicSubscription{
+		ctx:    ctx,
+		topic:  topic,
+		config: config,
+	}
 
+	for i := 0; i < config.SubscribersCount; i++ {
 		subscriberLogFields := watermill.LogFields{
 			"subscriber_num": i,
 			"topic":          topic,
@@ -279,28 +576,44 @@ func (s *Subscriber) Subscribe(ctx context.Context, topic string) (<-chan *messa
 
 		s.logger.Debug("Starting subscriber", subscriberLogFields)
 
-		sub, err := s.subscribe(topic, func(msg *nats.Msg) {
-			s.processMessage(ctx, msg, output, subscriberLogFields)
-		})
-		if err != nil {
-			return nil, errors.Wrap(err, "cannot subscribe")
+		handler := func(msg *nats.Msg) {
+			if !inFlight.acquire(ctx, s.closing) {
+				return
+			}
+			defer inFlight.release()
+
+			s.processMessage(ctx, config, topic, msg, output, subscriberLogFields)
+		}
+		if config.Workers > 1 {
+			handler = s.startPartitionWorkers(ctx, config, topic, output, subscriberLogFields, outputWg, inFlight)
 		}
 
-		go func(subscriber *nats.Subscription, subscriberLogFields watermill.LogFields) {
-			defer outputWg.Done()
-			select {
-			case <-s.closing:
-				// unblock
-			case <-ctx.Done():
-				// unblock
-			}
+		ts.handlers = append(ts.handlers, s.pausable(ts, handler, subscriberLogFields))
+	}
 
-			if err := sub.Unsubscribe(); err != nil {
-				s.logger.Error("Cannot unsubscribe", err, subscriberLogFields)
-			}
-		}(sub, subscriberLogFields)
+	ts.lock.Lock()
+	err = s.subscribeAll(ts)
+	ts.lock.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot subscribe")
 	}
 
+	s.addTopicSubscription(ts)
+
+	outputWg.Add(1)
+	go func() {
+		defer outputWg.Done()
+		select {
+		case <-s.closing:
+			// unblock
+		case <-ctx.Done():
+			// unblock
+		}
+
+		s.removeTopicSubscription(ts)
+		s.unsubscribeAll(ts)
+	}()
+
 	go func() {
 		defer s.outputsWg.Done()
 		outputWg.Wait()
@@ -310,6 +623,49 @@ func (s *Subscriber) Subscribe(ctx context.Context, topic string) (<-chan *messa
 	return output, nil
 }
 
+// topicConfig returns the subscription configuration of topic, with the overrides applied.
+func (s *Subscriber) topicConfig(topic string, override SubscriptionConfigOverride) (*SubscriberSubscriptionConfig, error) {
+	config := s.config
+	config.SubscribeOptions = append([]nats.SubOpt(nil), s.config.SubscribeOptions...)
+
+	if s.config.TopicConfigOverride != nil {
+		s.config.TopicConfigOverride(topic, &config)
+	}
+	if override != nil {
+		override(topic, &config)
+	}
+
+	config.setDefaults()
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	// the consumer of topic is known only here, DurableName may be set by the overrides
+	if config.CircuitBreaker != nil && config.ephemeralConsumer(topic) {
+		return nil, errors.New("SubscriberConfig.CircuitBreaker requires SubscriberConfig.DurableName or SubscriberConfig.QueueGroup")
+	}
+
+	return &config, nil
+}
+
+func (s *Subscriber) addActiveSubscription(topic string, sub *nats.Subscription) {
+	s.activeSubsLock.Lock()
+	defer s.activeSubsLock.Unlock()
+
+	s.activeSubs[sub] = &activeSubscription{
+		topic: topic,
+		sub:   sub,
+	}
+}
+
+func (s *Subscriber) removeActiveSubscription(sub *nats.Subscription) {
+	s.activeSubsLock.Lock()
+	defer s.activeSubsLock.Unlock()
+
+	delete(s.activeSubs, sub)
+}
+
 // SubscribeInitialize offers a way to ensure the stream for a topic exists prior to subscribe
 func (s *Subscriber) SubscribeInitialize(topic string) error {
 	err := s.topicInterpreter.ensureStream(topic)
@@ -321,27 +677,29 @@ func (s *Subscriber) SubscribeInitialize(topic string) error {
 	return nil
 }
 
-func (s *Subscriber) subscribe(topic string, cb nats.MsgHandler) (*nats.Subscription, error) {
-	if s.config.AutoProvision {
-		err := s.SubscribeInitialize(topic)
+func (s *Subscriber) subscribe(config *SubscriberSubscriptionConfig, topic string, cb nats.MsgHandler) (*nats.Subscription, error) {
+	if config.AutoProvision {
+		err := newTopicInterpreter(s.js, config.SubjectCalculator).ensureStream(topic)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrap(err, "cannot initialize subscribe")
 		}
 	}
 
-	primarySubject := s.config.SubjectCalculator(topic).Primary
+	primarySubject := config.SubjectCalculator(topic).Primary
 
-	opts := s.config.SubscribeOptions
+	opts := append(config.consumerOptions(topic), config.flowControlOptions()...)
+	opts = append(opts, config.rateLimitOptions()...)
+	opts = append(opts, config.SubscribeOptions...)
 
-	if s.config.DurableName != "" {
-		opts = append(opts, nats.Durable(s.config.DurableName))
+	if durableName := config.durableName(topic); durableName != "" {
+		opts = append(opts, nats.Durable(durableName))
 	} else {
 		opts = append(opts, nats.BindStream(""))
 	}
 
 	return s.js.QueueSubscribe(
 		primarySubject,
-		s.config.QueueGroup,
+		config.QueueGroup,
 		cb,
 		opts...,
 	)
@@ -349,6 +707,8 @@ func (s *Subscriber) subscribe(topic string, cb nats.MsgHandler) (*nats.Subscrip
 
 func (s *Subscriber) processMessage(
 	ctx context.Context,
+	config *SubscriberSubscriptionConfig,
+	topic string,
 	m *nats.Msg,
 	output chan *message.Message,
 	logFields watermill.LogFields,
@@ -359,12 +719,56 @@ func (s *Subscriber) processMessage(
 
 	s.logger.Trace("Received message", logFields)
 
-	msg, err := s.config.Unmarshaler.Unmarshal(m)
+	var consumeErr error
+	if config.Tracer != nil {
+		var endSpan func(error)
+		ctx, endSpan = config.Tracer.StartConsume(ctx, topic, m)
+		defer func() {
+			endSpan(consumeErr)
+		}()
+	}
+
+	metrics := config.Metrics
+	if metrics != nil {
+		metrics.MessageReceived(ctx, topic)
+
+		if metadata, err := m.Metadata(); err == nil {
+			metrics.ConsumerLag(ctx, topic, metadata.NumPending)
+		}
+	}
+
+	var msg *message.Message
+
+	natsMsg, err := s.claimChecks.resolve(m)
+	if err == nil {
+		msg, err = config.Unmarshaler.Unmarshal(natsMsg)
+	}
 	if err != nil {
 		s.logger.Error("Cannot unmarshal message", err, logFields)
+		consumeErr = errors.Wrap(err, "cannot unmarshal message")
+		if metrics != nil {
+			metrics.MessageUnmarshalFailed(ctx, topic)
+		}
+
+		if isPermanentError(err) {
+			if err := m.Term(); err != nil {
+				s.logger.Error("Cannot send term", err, logFields)
+				return
+			}
+			s.logger.Trace("Message Termed", logFields)
+
+			if metrics != nil {
+				metrics.MessageTermed(ctx, topic)
+			}
+		}
 		return
 	}
 
+	if metrics != nil {
+		metrics.InFlightChanged(ctx, topic, 1)
+		defer metrics.InFlightChanged(ctx, topic, -1)
+	}
+
 	ctx, cancelCtx := context.WithCancel(ctx)
 	msg.SetContext(ctx)
 	defer cancelCtx()
@@ -372,12 +776,20 @@ func (s *Subscriber) processMessage(
 	messageLogFields := logFields.Add(watermill.LogFields{"message_uuid": msg.UUID})
 	s.logger.Trace("Unmarshaled message", messageLogFields)
 
+	if !s.rateLimiters.wait(ctx, s.closing, topic, config.rateLimit(topic)) {
+		s.logger.Trace("Message discarded while rate limited", messageLogFields)
+		consumeErr = ErrMessageDiscarded
+		return
+	}
+
 	select {
 	case <-s.closing:
 		s.logger.Trace("Closing, message discarded", messageLogFields)
+		consumeErr = ErrMessageDiscarded
 		return
 	case <-ctx.Done():
 		s.logger.Trace("Context cancelled, message discarded", messageLogFields)
+		consumeErr = ErrMessageDiscarded
 		return
 	// if this is first can risk 'send on closed channel' errors
 	case output <- msg:
@@ -388,7 +800,7 @@ func (s *Subscriber) processMessage(
 	case <-msg.Acked():
 		var err error
 
-		if s.config.AckSync {
+		if config.AckSync {
 			err = m.AckSync()
 		} else {
 			err = m.Ack()
@@ -396,18 +808,29 @@ func (s *Subscriber) processMessage(
 
 		if err != nil {
 			s.logger.Error("Cannot send ack", err, messageLogFields)
+			consumeErr = errors.Wrap(err, "cannot send ack")
 			return
 		}
 		s.logger.Trace("Message Acked", messageLogFields)
+
+		if config.ClaimCheck != nil && config.ClaimCheck.DeleteOnAck {
+			if err := s.claimChecks.delete(m); err != nil {
+				s.logger.Error("Cannot delete claim-checked message data", err, messageLogFields)
+			}
+		}
+
+		if metrics != nil {
+			metrics.MessageAcked(ctx, topic)
+		}
 	case <-msg.Nacked():
 		var nakDelay time.Duration
 
-		if s.config.NakDelay != nil {
+		if config.NakDelay != nil {
 			metadata, err := m.Metadata()
 			if err != nil {
 				s.logger.Error("Cannot parse nats message metadata, use nak without delay", err, messageLogFields)
 			} else {
-				nakDelay = s.config.NakDelay.WaitTime(metadata.NumDelivered)
+				nakDelay = config.NakDelay.WaitTime(metadata.NumDelivered)
 				messageLogFields = messageLogFields.Add(watermill.LogFields{
 					"delay":    nakDelay.String(),
 					"retryNum": metadata.NumDelivered,
@@ -416,32 +839,54 @@ func (s *Subscriber) processMessage(
 		}
 
 		if nakDelay == StopTime {
+			consumeErr = ErrMessageTermed
 			if err := m.Term(); err != nil {
 				s.logger.Error("Cannot send term", err, messageLogFields)
 				return
 			}
+
+			if metrics != nil {
+				metrics.MessageTermed(ctx, topic)
+			}
 		} else if nakDelay > 0 {
+			consumeErr = ErrMessageNacked
 			if err := m.NakWithDelay(nakDelay); err != nil {
 				s.logger.Error("Cannot send nak", err, messageLogFields)
 				return
 			}
+
+			if metrics != nil {
+				metrics.MessageNacked(ctx, topic, nakDelay)
+			}
 		} else {
+			consumeErr = ErrMessageNacked
 			if err := m.Nak(); err != nil {
 				s.logger.Error("Cannot send nak", err, messageLogFields)
 				return
 			}
+
+			if metrics != nil {
+				metrics.MessageNacked(ctx, topic, 0)
+			}
 		}
 
 		s.logger.Trace("Message Nacked", messageLogFields)
 		return
-	case <-time.After(s.config.AckWaitTimeout):
+	case <-time.After(config.AckWaitTimeout):
 		s.logger.Trace("Ack timeout", messageLogFields)
+		consumeErr = ErrAckTimeout
+
+		if metrics != nil {
+			metrics.MessageAckTimedOut(ctx, topic)
+		}
 		return
 	case <-s.closing:
 		s.logger.Trace("Closing, message discarded before ack", messageLogFields)
+		consumeErr = ErrMessageDiscarded
 		return
 	case <-ctx.Done():
 		s.logger.Trace("Context cancelled, message discarded before ack", messageLogFields)
+		consumeErr = ErrMessageDiscarded
 		return
 	}
 }
@@ -472,6 +917,67 @@ func (s *Subscriber) Close() error {
 	return nil
 }
 
+// Ready returns true when the subscriber is not closed and its connection is established.
+func (s *Subscriber) Ready() bool {
+	return !s.isClosed() && s.conn.IsConnected()
+}
+
+// Health reports the state of the connection, the JetStream account and every active subscription.
+func (s *Subscriber) Health(ctx context.Context) HealthStatus {
+	status := connectionHealth(ctx, s.conn, s.js)
+
+	s.activeSubsLock.RLock()
+	subs := make([]*activeSubscription, 0, len(s.activeSubs))
+	for _, active := range s.activeSubs {
+		subs = append(subs, active)
+	}
+	s.activeSubsLock.RUnlock()
+
+	for _, active := range subs {
+		status.Subscriptions = append(status.Subscriptions, active.health(ctx))
+	}
+
+	return status
+}
+
+func (a *activeSubscription) health(ctx context.Context) SubscriptionHealth {
+	health := SubscriptionHealth{
+		Topic: a.topic,
+	}
+
+	if err := ctx.Err(); err != nil {
+		health.Error = err.Error()
+		return health
+	}
+
+	info, err := a.sub.ConsumerInfo()
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		return health
+	} else if err != nil {
+		health.Error = errors.Wrap(err, "cannot get consumer info").Error()
+		return health
+	}
+
+	health.Stream = info.Stream
+	health.Consumer = info.Name
+	health.ConsumerExists = true
+	health.NumPending = info.NumPending
+	health.NumAckPending = info.NumAckPending
+	health.NumRedelivered = info.NumRedelivered
+	health.HeartbeatInterval = info.Config.Heartbeat
+	health.LastDelivery = info.Delivered.Last
+
+	if health.HeartbeatInterval > 0 {
+		a.lock.Lock()
+		lastNotActive := a.lastNotActive
+		a.lock.Unlock()
+
+		health.HeartbeatsFlowing = time.Since(lastNotActive) > heartbeatMissThreshold*health.HeartbeatInterval
+	}
+
+	return health
+}
+
 func (s *Subscriber) isClosed() bool {
 	s.subsLock.RLock()
 	defer s.subsLock.RUnlock()
@@ -2,7 +2,9 @@ package jetstream
 
 import (
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -13,6 +15,12 @@ func TestSubscriberSubscriptionConfig_Validate(t *testing.T) {
 		queueGroup        string
 		subscribersCount  int
 		SubjectCalculator func(string) *Subjects
+		workers           int
+		partitionKey      PartitionKeyFunc
+		idleHeartbeat     time.Duration
+		rateLimit         *RateLimit
+		claimCheck        *ClaimCheckConfig
+		ackSync           bool
 		wantErr           bool
 	}{
 		{name: "OK - 1 Subscriber", unmarshaler: &GobMarshaler{}, subscribersCount: 1, wantErr: false, SubjectCalculator: defaultSubjectCalculator},
@@ -20,6 +28,15 @@ func TestSubscriberSubscriptionConfig_Validate(t *testing.T) {
 		{name: "Invalid - Multi Subscriber no QueueGroup", unmarshaler: &GobMarshaler{}, subscribersCount: 3, wantErr: true, SubjectCalculator: defaultSubjectCalculator},
 		{name: "Invalid - No Unmarshaler", unmarshaler: nil, subscribersCount: 3, queueGroup: "not empty", wantErr: true, SubjectCalculator: defaultSubjectCalculator},
 		{name: "Invalid - No Subject Calculator", unmarshaler: &GobMarshaler{}, subscribersCount: 3, queueGroup: "not empty", wantErr: true, SubjectCalculator: nil},
+		{name: "OK - Workers + Partition Key", unmarshaler: &GobMarshaler{}, subscribersCount: 1, workers: 4, partitionKey: SubjectTokenPartitionKey(1), wantErr: false, SubjectCalculator: defaultSubjectCalculator},
+		{name: "OK - Idle Heartbeat", unmarshaler: &GobMarshaler{}, subscribersCount: 1, idleHeartbeat: time.Second, wantErr: false, SubjectCalculator: defaultSubjectCalculator},
+		{name: "Invalid - Idle Heartbeat + Queue Group", unmarshaler: &GobMarshaler{}, subscribersCount: 1, queueGroup: "not empty", idleHeartbeat: time.Second, wantErr: true, SubjectCalculator: defaultSubjectCalculator},
+		{name: "OK - Rate Limit", unmarshaler: &GobMarshaler{}, subscribersCount: 1, rateLimit: &RateLimit{MessagesPerSecond: 10}, wantErr: false, SubjectCalculator: defaultSubjectCalculator},
+		{name: "Invalid - Rate Limit without rate", unmarshaler: &GobMarshaler{}, subscribersCount: 1, rateLimit: &RateLimit{Burst: 10}, wantErr: true, SubjectCalculator: defaultSubjectCalculator},
+		{name: "OK - Claim Check Delete On Ack + Ack Sync", unmarshaler: &GobMarshaler{}, subscribersCount: 1, claimCheck: &ClaimCheckConfig{DeleteOnAck: true}, ackSync: true, wantErr: false, SubjectCalculator: defaultSubjectCalculator},
+		{name: "Invalid - Claim Check Delete On Ack no Ack Sync", unmarshaler: &GobMarshaler{}, subscribersCount: 1, claimCheck: &ClaimCheckConfig{DeleteOnAck: true}, wantErr: true, SubjectCalculator: defaultSubjectCalculator},
+		{name: "Invalid - Workers + Multi Subscriber", unmarshaler: &GobMarshaler{}, subscribersCount: 2, queueGroup: "not empty", workers: 4, partitionKey: SubjectTokenPartitionKey(1), wantErr: true, SubjectCalculator: defaultSubjectCalculator},
+		{name: "Invalid - Workers no Partition Key", unmarshaler: &GobMarshaler{}, subscribersCount: 1, workers: 4, wantErr: true, SubjectCalculator: defaultSubjectCalculator},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -28,6 +45,12 @@ func TestSubscriberSubscriptionConfig_Validate(t *testing.T) {
 				QueueGroup:        tt.queueGroup,
 				SubscribersCount:  tt.subscribersCount,
 				SubjectCalculator: tt.SubjectCalculator,
+				Workers:           tt.workers,
+				PartitionKey:      tt.partitionKey,
+				IdleHeartbeat:     tt.idleHeartbeat,
+				RateLimit:         tt.rateLimit,
+				ClaimCheck:        tt.claimCheck,
+				AckSync:           tt.ackSync,
 			}
 
 			if tt.wantErr {
@@ -38,3 +61,30 @@ func TestSubscriberSubscriptionConfig_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestIsPermanentError(t *testing.T) {
+	signatureErr := &SignatureError{KeyID: "key", Err: ErrMissingSignature}
+
+	require.True(t, isPermanentError(signatureErr))
+	require.True(t, isPermanentError(errors.Wrap(signatureErr, "cannot unmarshal")))
+	require.False(t, isPermanentError(errors.New("cannot decode message")))
+	require.False(t, isPermanentError(nil))
+}
+
+func TestTopicDurableNameCalculator(t *testing.T) {
+	tests := []struct {
+		topic    string
+		group    string
+		expected string
+	}{
+		{topic: "orders", group: "billing", expected: "billing_orders"},
+		{topic: "orders.created", group: "billing", expected: "billing_orders_created"},
+		{topic: "orders.*.>", group: "billing", expected: "billing_orders____"},
+		{topic: "orders/eu west", group: "billing", expected: "billing_orders_eu_west"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			require.Equal(t, tt.expected, TopicDurableNameCalculator(tt.topic, tt.group))
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package jetstream
 
 import (
+	"strings"
+
 	"github.com/nats-io/nats.go"
 )
 
@@ -18,6 +20,38 @@ func (s *Subjects) All() []string {
 	return append([]string{s.Primary}, s.Additional...)
 }
 
+// DurableNameCalculator is a function used to calculate the durable consumer name of a topic
+// from the configured DurableName (group).
+type DurableNameCalculator func(topic, group string) string
+
+// durableNameReplacer replaces characters which are not allowed in consumer names.
+var durableNameReplacer = strings.NewReplacer(
+	".", "_",
+	"*", "_",
+	">", "_",
+	"/", "_",
+	"\\", "_",
+	" ", "_",
+	"\t", "_",
+	"\n", "_",
+	"\r", "_",
+)
+
+// TopicDurableNameCalculator names consumers "{group}_{topic}", with characters not allowed in consumer names
+// replaced by "_", so topics sharing a stream get distinct consumers.
+func TopicDurableNameCalculator(topic, group string) string {
+	return group + "_" + durableNameReplacer.Replace(topic)
+}
+
+// durableName returns the durable name of topic, or an empty string when it is not set.
+func (c SubscriberSubscriptionConfig) durableName(topic string) string {
+	if c.DurableName == "" || c.DurableNameCalculator == nil {
+		return c.DurableName
+	}
+
+	return c.DurableNameCalculator(topic, c.DurableName)
+}
+
 type topicInterpreter struct {
 	js                nats.JetStreamManager
 	subjectCalculator SubjectCalculator
@@ -44,11 +78,7 @@ func (b *topicInterpreter) ensureStream(topic string) error {
 	_, err := b.js.StreamInfo(topic)
 
 	if err != nil {
-		_, err = b.js.AddStream(&nats.StreamConfig{
-			Name:        topic,
-			Description: "",
-			Subjects:    b.subjectCalculator(topic).All(),
-		})
+		_, err = b.js.AddStream(b.streamConfig(topic))
 
 		if err != nil {
 			return err
@@ -57,3 +87,12 @@ func (b *topicInterpreter) ensureStream(topic string) error {
 
 	return err
 }
+
+// streamConfig returns the configuration of the stream storing topic, the stream is named after the topic.
+func (b *topicInterpreter) streamConfig(topic string) *nats.StreamConfig {
+	return &nats.StreamConfig{
+		Name:        topic,
+		Description: "",
+		Subjects:    b.subjectCalculator(topic).All(),
+	}
+}
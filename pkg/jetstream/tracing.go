@@ -0,0 +1,40 @@
+package jetstream
+
+import (
+	"context"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMessageNacked is passed to the consume span when the message was negatively acknowledged.
+	ErrMessageNacked = errors.New("message nacked")
+
+	// ErrMessageTermed is passed to the consume span when the message was terminated.
+	ErrMessageTermed = errors.New("message termed")
+
+	// ErrAckTimeout is passed to the consume span when the message was not acked within AckWaitTimeout.
+	ErrAckTimeout = errors.New("ack timeout")
+
+	// ErrMessageDiscarded is passed to the consume span when the message was discarded before ack, because
+	// the subscriber was closed or the context was cancelled.
+	ErrMessageDiscarded = errors.New("message discarded")
+)
+
+// Tracer is used to propagate trace context through NATS headers and to create spans around publish and consume.
+//
+// Tracing is optional, when no implementation is provided nothing is done.
+// See the wmotel package for an OpenTelemetry implementation.
+type Tracer interface {
+	// StartPublish is called after msg was marshaled to natsMsg, before it is published.
+	// It should inject the trace context into natsMsg.Header.
+	// The returned function is called with the JetStream ack (nil on failure) once publishing finished.
+	StartPublish(ctx context.Context, topic string, msg *message.Message, natsMsg *nats.Msg) func(ack *nats.PubAck, err error)
+
+	// StartConsume is called when natsMsg was received, before it is unmarshaled.
+	// It should extract the trace context from natsMsg.Header, the returned context is set on the watermill message.
+	// The returned function is called once the message was acked (with nil) or not (with the reason).
+	StartConsume(ctx context.Context, topic string, natsMsg *nats.Msg) (context.Context, func(err error))
+}
@@ -0,0 +1,196 @@
+package wmotel
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+
+var (
+	topicKey   = attribute.Key("messaging.destination.name")
+	successKey = attribute.Key("success")
+)
+
+// Metrics is an OpenTelemetry implementation of jetstream.Metrics.
+type Metrics struct {
+	publishDuration  metric.Float64Histogram
+	publishErrors    metric.Int64Counter
+	received         metric.Int64Counter
+	unmarshalErrors  metric.Int64Counter
+	acked            metric.Int64Counter
+	nacked           metric.Int64Counter
+	nakDelay         metric.Float64Histogram
+	termed           metric.Int64Counter
+	ackTimeouts      metric.Int64Counter
+	inFlight         metric.Int64UpDownCounter
+	consumerLagGauge metric.Int64ObservableGauge
+
+	consumerLagLock sync.Mutex
+	consumerLag     map[string]int64
+}
+
+var _ jetstream.Metrics = (*Metrics)(nil)
+
+// NewMetrics creates a new Metrics using the meter provided by provider.
+func NewMetrics(provider metric.MeterProvider) (*Metrics, error) {
+	meter := provider.Meter(instrumentationName)
+
+	m := &Metrics{
+		consumerLag: make(map[string]int64),
+	}
+
+	var err error
+
+	if m.publishDuration, err = meter.Float64Histogram(
+		"jetstream.publish.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of message publication, including waiting for the JetStream ack"),
+	); err != nil {
+		return nil, err
+	}
+	if m.publishErrors, err = meter.Int64Counter(
+		"jetstream.publish.errors",
+		metric.WithDescription("Number of messages which failed to be published"),
+	); err != nil {
+		return nil, err
+	}
+	if m.received, err = meter.Int64Counter(
+		"jetstream.messages.received",
+		metric.WithDescription("Number of messages received from JetStream"),
+	); err != nil {
+		return nil, err
+	}
+	if m.unmarshalErrors, err = meter.Int64Counter(
+		"jetstream.messages.unmarshal_errors",
+		metric.WithDescription("Number of received messages which could not be unmarshaled"),
+	); err != nil {
+		return nil, err
+	}
+	if m.acked, err = meter.Int64Counter(
+		"jetstream.messages.acked",
+		metric.WithDescription("Number of acknowledged messages"),
+	); err != nil {
+		return nil, err
+	}
+	if m.nacked, err = meter.Int64Counter(
+		"jetstream.messages.nacked",
+		metric.WithDescription("Number of negatively acknowledged messages"),
+	); err != nil {
+		return nil, err
+	}
+	if m.nakDelay, err = meter.Float64Histogram(
+		"jetstream.messages.nak_delay",
+		metric.WithUnit("s"),
+		metric.WithDescription("Redelivery delay of negatively acknowledged messages"),
+	); err != nil {
+		return nil, err
+	}
+	if m.termed, err = meter.Int64Counter(
+		"jetstream.messages.termed",
+		metric.WithDescription("Number of terminated messages"),
+	); err != nil {
+		return nil, err
+	}
+	if m.ackTimeouts, err = meter.Int64Counter(
+		"jetstream.messages.ack_timeouts",
+		metric.WithDescription("Number of messages not acknowledged within the ack wait timeout"),
+	); err != nil {
+		return nil, err
+	}
+	if m.inFlight, err = meter.Int64UpDownCounter(
+		"jetstream.messages.in_flight",
+		metric.WithDescription("Number of messages being processed"),
+	); err != nil {
+		return nil, err
+	}
+	if m.consumerLagGauge, err = meter.Int64ObservableGauge(
+		"jetstream.consumer.lag",
+		metric.WithDescription("Number of messages pending for the consumer"),
+	); err != nil {
+		return nil, err
+	}
+
+	if _, err = meter.RegisterCallback(m.observeConsumerLag, m.consumerLagGauge); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Metrics) observeConsumerLag(_ context.Context, observer metric.Observer) error {
+	m.consumerLagLock.Lock()
+	defer m.consumerLagLock.Unlock()
+
+	for topic, lag := range m.consumerLag {
+		observer.ObserveInt64(m.consumerLagGauge, lag, metric.WithAttributes(topicKey.String(topic)))
+	}
+
+	return nil
+}
+
+// MessagePublished records the publish duration and errors.
+func (m *Metrics) MessagePublished(ctx context.Context, topic string, duration time.Duration, err error) {
+	m.publishDuration.Record(
+		ctx,
+		duration.Seconds(),
+		metric.WithAttributes(topicKey.String(topic), successKey.Bool(err == nil)),
+	)
+
+	if err != nil {
+		m.publishErrors.Add(ctx, 1, topicAttributes(topic))
+	}
+}
+
+// MessageReceived records a received message.
+func (m *Metrics) MessageReceived(ctx context.Context, topic string) {
+	m.received.Add(ctx, 1, topicAttributes(topic))
+}
+
+// MessageUnmarshalFailed records an unmarshal failure.
+func (m *Metrics) MessageUnmarshalFailed(ctx context.Context, topic string) {
+	m.unmarshalErrors.Add(ctx, 1, topicAttributes(topic))
+}
+
+// MessageAcked records an acked message.
+func (m *Metrics) MessageAcked(ctx context.Context, topic string) {
+	m.acked.Add(ctx, 1, topicAttributes(topic))
+}
+
+// MessageNacked records a nacked message and its redelivery delay.
+func (m *Metrics) MessageNacked(ctx context.Context, topic string, delay time.Duration) {
+	m.nacked.Add(ctx, 1, topicAttributes(topic))
+	m.nakDelay.Record(ctx, delay.Seconds(), topicAttributes(topic))
+}
+
+// MessageTermed records a terminated message.
+func (m *Metrics) MessageTermed(ctx context.Context, topic string) {
+	m.termed.Add(ctx, 1, topicAttributes(topic))
+}
+
+// MessageAckTimedOut records a message which was not acked in time.
+func (m *Metrics) MessageAckTimedOut(ctx context.Context, topic string) {
+	m.ackTimeouts.Add(ctx, 1, topicAttributes(topic))
+}
+
+// InFlightChanged records the change of the number of messages being processed.
+func (m *Metrics) InFlightChanged(ctx context.Context, topic string, delta int64) {
+	m.inFlight.Add(ctx, delta, topicAttributes(topic))
+}
+
+// ConsumerLag stores the consumer lag, which is reported when metrics are collected.
+func (m *Metrics) ConsumerLag(_ context.Context, topic string, pending uint64) {
+	m.consumerLagLock.Lock()
+	defer m.consumerLagLock.Unlock()
+
+	m.consumerLag[topic] = int64(pending)
+}
+
+func topicAttributes(topic string) metric.MeasurementOption {
+	return metric.WithAttributes(topicKey.String(topic))
+}
@@ -0,0 +1,69 @@
+package wmotel_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream/wmotel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+
+	metrics, err := wmotel.NewMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	require.NoError(t, err)
+
+	metrics.MessagePublished(ctx, "topic", time.Millisecond, nil)
+	metrics.MessagePublished(ctx, "topic", time.Millisecond, errors.New("failed"))
+	metrics.MessageReceived(ctx, "topic")
+	metrics.MessageReceived(ctx, "topic")
+	metrics.MessageUnmarshalFailed(ctx, "topic")
+	metrics.InFlightChanged(ctx, "topic", 1)
+	metrics.MessageAcked(ctx, "topic")
+	metrics.MessageNacked(ctx, "topic", time.Second)
+	metrics.MessageTermed(ctx, "topic")
+	metrics.MessageAckTimedOut(ctx, "topic")
+	metrics.ConsumerLag(ctx, "topic", 42)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &rm))
+
+	collected := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			collected[m.Name] = m.Data
+		}
+	}
+
+	for name, want := range map[string]int64{
+		"jetstream.publish.errors":            1,
+		"jetstream.messages.received":         2,
+		"jetstream.messages.unmarshal_errors": 1,
+		"jetstream.messages.acked":            1,
+		"jetstream.messages.nacked":           1,
+		"jetstream.messages.termed":           1,
+		"jetstream.messages.ack_timeouts":     1,
+		"jetstream.messages.in_flight":        1,
+	} {
+		sum, ok := collected[name].(metricdata.Sum[int64])
+		require.True(t, ok, name)
+		require.Len(t, sum.DataPoints, 1, name)
+		assert.Equal(t, want, sum.DataPoints[0].Value, name)
+	}
+
+	publishDuration, ok := collected["jetstream.publish.duration"].(metricdata.Histogram[float64])
+	require.True(t, ok)
+	assert.Len(t, publishDuration.DataPoints, 2)
+
+	lag, ok := collected["jetstream.consumer.lag"].(metricdata.Gauge[int64])
+	require.True(t, ok)
+	require.Len(t, lag.DataPoints, 1)
+	assert.Equal(t, int64(42), lag.DataPoints[0].Value)
+}
@@ -0,0 +1,156 @@
+package wmotel
+
+import (
+	"context"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	streamKey           = attribute.Key("messaging.nats.stream")
+	sequenceKey         = attribute.Key("messaging.nats.sequence")
+	consumerKey         = attribute.Key("messaging.nats.consumer")
+	deliveredCountKey   = attribute.Key("messaging.nats.num_delivered")
+	watermillTopicKey   = attribute.Key("messaging.watermill.topic")
+	natsMessagingSystem = semconv.MessagingSystem("nats")
+)
+
+// Tracer is an OpenTelemetry implementation of jetstream.Tracer.
+//
+// It propagates the W3C trace context (traceparent/tracestate) through NATS headers,
+// so it works with every marshaler.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+var _ jetstream.Tracer = (*Tracer)(nil)
+
+// NewTracer creates a new Tracer using the tracer provided by provider and the W3C trace context propagator.
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	return NewTracerWithPropagator(provider, propagation.TraceContext{})
+}
+
+// NewTracerWithPropagator creates a new Tracer using a custom propagator.
+func NewTracerWithPropagator(provider trace.TracerProvider, propagator propagation.TextMapPropagator) *Tracer {
+	return &Tracer{
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagator,
+	}
+}
+
+// StartPublish starts a producer span and injects its context into the NATS headers.
+func (t *Tracer) StartPublish(
+	ctx context.Context,
+	topic string,
+	msg *message.Message,
+	natsMsg *nats.Msg,
+) func(ack *nats.PubAck, err error) {
+	ctx, span := t.tracer.Start(
+		ctx,
+		natsMsg.Subject+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			natsMessagingSystem,
+			semconv.MessagingOperationPublish,
+			semconv.MessagingDestinationName(natsMsg.Subject),
+			semconv.MessagingMessageID(msg.UUID),
+			watermillTopicKey.String(topic),
+		),
+	)
+
+	if natsMsg.Header == nil {
+		natsMsg.Header = make(nats.Header)
+	}
+
+	t.propagator.Inject(ctx, HeaderCarrier(natsMsg.Header))
+
+	return func(ack *nats.PubAck, err error) {
+		if ack != nil {
+			span.SetAttributes(
+				streamKey.String(ack.Stream),
+				sequenceKey.Int64(int64(ack.Sequence)),
+			)
+		}
+
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.End()
+	}
+}
+
+// StartConsume extracts the trace context from the NATS headers and starts a consumer span.
+func (t *Tracer) StartConsume(ctx context.Context, topic string, natsMsg *nats.Msg) (context.Context, func(err error)) {
+	if natsMsg.Header != nil {
+		ctx = t.propagator.Extract(ctx, HeaderCarrier(natsMsg.Header))
+	}
+
+	attributes := []attribute.KeyValue{
+		natsMessagingSystem,
+		semconv.MessagingOperationProcess,
+		semconv.MessagingDestinationName(natsMsg.Subject),
+		watermillTopicKey.String(topic),
+	}
+
+	if metadata, err := natsMsg.Metadata(); err == nil {
+		attributes = append(
+			attributes,
+			streamKey.String(metadata.Stream),
+			sequenceKey.Int64(int64(metadata.Sequence.Stream)),
+			consumerKey.String(metadata.Consumer),
+			deliveredCountKey.Int64(int64(metadata.NumDelivered)),
+		)
+	}
+
+	ctx, span := t.tracer.Start(
+		ctx,
+		natsMsg.Subject+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes...),
+	)
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.End()
+	}
+}
+
+// HeaderCarrier adapts nats.Header to propagation.TextMapCarrier.
+//
+// Contrary to propagation.HeaderCarrier, keys are not canonicalized, which is consistent with nats.Header.
+type HeaderCarrier nats.Header
+
+var _ propagation.TextMapCarrier = HeaderCarrier(nil)
+
+// Get returns the first value associated with key.
+func (c HeaderCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+// Set sets the value associated with key, replacing existing values.
+func (c HeaderCarrier) Set(key string, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+// Keys lists the keys stored in the carrier.
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,147 @@
+package wmotel_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream/wmotel"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracer(t *testing.T) {
+	marshalers := []jetstream.MarshalerUnmarshaler{
+		&jetstream.GobMarshaler{},
+		&jetstream.JSONMarshaler{},
+		&jetstream.NATSMarshaler{},
+	}
+
+	for _, marshaler := range marshalers {
+		recorder := tracetest.NewSpanRecorder()
+		tracer := wmotel.NewTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+		msg := message.NewMessage("uuid", []byte("payload"))
+
+		natsMsg, err := marshaler.Marshal("topic", msg)
+		require.NoError(t, err)
+
+		endPublish := tracer.StartPublish(context.Background(), "topic", msg, natsMsg)
+		endPublish(&nats.PubAck{Stream: "stream", Sequence: 5}, nil)
+
+		assert.NotEmpty(t, natsMsg.Header.Get("traceparent"))
+
+		// JetStream reply subject, as set by the server on delivery
+		natsMsg.Reply = "$JS.ACK.stream.consumer.1.5.3.1660000000000000000.0"
+		natsMsg.Sub = &nats.Subscription{}
+
+		ctx, endConsume := tracer.StartConsume(context.Background(), "topic", natsMsg)
+		endConsume(jetstream.ErrMessageNacked)
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 2)
+
+		publishSpan, consumeSpan := spans[0], spans[1]
+
+		assert.Equal(t, trace.SpanKindProducer, publishSpan.SpanKind())
+		assert.Contains(t, publishSpan.Attributes(), attribute.Int64("messaging.nats.sequence", 5))
+
+		assert.Equal(t, trace.SpanKindConsumer, consumeSpan.SpanKind())
+		assert.Equal(t, publishSpan.SpanContext().TraceID(), consumeSpan.SpanContext().TraceID())
+		assert.Equal(t, publishSpan.SpanContext().SpanID(), consumeSpan.Parent().SpanID())
+		assert.Equal(t, consumeSpan.SpanContext().SpanID(), trace.SpanContextFromContext(ctx).SpanID())
+		assert.Contains(t, consumeSpan.Attributes(), attribute.String("messaging.nats.stream", "stream"))
+		assert.Contains(t, consumeSpan.Attributes(), attribute.Int64("messaging.nats.sequence", 5))
+		assert.Equal(t, codes.Error, consumeSpan.Status().Code)
+	}
+}
+
+func TestTracer_Publisher_Subscriber(t *testing.T) {
+	natsURL := os.Getenv("WATERMILL_TEST_NATS_URL")
+	if natsURL == "" {
+		natsURL = nats.DefaultURL
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := wmotel.NewTracer(provider)
+
+	conn, err := nats.Connect(natsURL)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	js, err := conn.JetStream()
+	require.NoError(t, err)
+
+	topic := "tracing_" + watermill.NewShortUUID()
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	logger := watermill.NewStdLogger(false, false)
+
+	pub, err := jetstream.NewPublisher(jetstream.PublisherConfig{
+		URL:           natsURL,
+		Marshaler:     &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		Tracer:        tracer,
+	}, logger)
+	require.NoError(t, err)
+	defer pub.Close()
+
+	sub, err := jetstream.NewSubscriber(jetstream.SubscriberConfig{
+		URL:           natsURL,
+		Unmarshaler:   &jetstream.NATSMarshaler{},
+		AutoProvision: true,
+		Tracer:        tracer,
+	}, logger)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	parentCtx, parentSpan := provider.Tracer("test").Start(context.Background(), "handler")
+	defer parentSpan.End()
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+	msg.SetContext(parentCtx)
+	require.NoError(t, pub.Publish(topic, msg))
+
+	var received *message.Message
+	select {
+	case received = <-messages:
+	case <-ctx.Done():
+		t.Fatal("message not received")
+	}
+
+	consumeSpanContext := trace.SpanContextFromContext(received.Context())
+	require.True(t, consumeSpanContext.IsValid(), "consume span should be in the message context")
+	assert.Equal(t, parentSpan.SpanContext().TraceID(), consumeSpanContext.TraceID())
+
+	received.Ack()
+
+	require.Eventually(t, func() bool {
+		return len(recorder.Ended()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	publishSpan, consumeSpan := recorder.Ended()[0], recorder.Ended()[1]
+	assert.Equal(t, trace.SpanKindProducer, publishSpan.SpanKind())
+	assert.Equal(t, parentSpan.SpanContext().SpanID(), publishSpan.Parent().SpanID())
+	assert.Equal(t, trace.SpanKindConsumer, consumeSpan.SpanKind())
+	assert.Equal(t, publishSpan.SpanContext().SpanID(), consumeSpan.Parent().SpanID())
+	assert.Equal(t, consumeSpanContext.SpanID(), consumeSpan.SpanContext().SpanID())
+}
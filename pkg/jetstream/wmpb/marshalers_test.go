@@ -1,10 +1,12 @@
 package wmpb_test
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
@@ -14,6 +16,7 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 )
 
 type marshalerCase struct {
@@ -25,7 +28,28 @@ var marshalerCases = []marshalerCase{
 	{"gob", &jetstream.GobMarshaler{}},
 	{"json", &jetstream.JSONMarshaler{}},
 	{"proto", &wmpb.NATSMarshaler{}},
+	{"proto-headers", &wmpb.NATSMarshaler{HeaderTransport: true}},
 	{"nats", &jetstream.NATSMarshaler{}},
+	{"gzip-json", &jetstream.CompressionMarshaler{Marshaler: &jetstream.JSONMarshaler{}, Compressor: jetstream.GzipCompressor{}}},
+	{"zstd-nats", &jetstream.CompressionMarshaler{Marshaler: &jetstream.NATSMarshaler{}, Compressor: jetstream.ZstdCompressor{}}},
+	{"snappy-proto", &jetstream.CompressionMarshaler{Marshaler: &wmpb.NATSMarshaler{}, Compressor: jetstream.SnappyCompressor{}}},
+	{"encrypted-gob", &jetstream.EncryptionMarshaler{Marshaler: &jetstream.GobMarshaler{}, KeyProvider: testKeyRing}},
+	{"encrypted-nats", &jetstream.EncryptionMarshaler{Marshaler: &jetstream.NATSMarshaler{}, KeyProvider: testKeyRing, EncryptMetadata: true}},
+	{"signed-json", &jetstream.SigningMarshaler{Marshaler: &jetstream.JSONMarshaler{}, Signer: testHMACSigner, Verifiers: jetstream.Verifiers{"hmac-1": testHMACSigner}}},
+	{"signed-nats", &jetstream.SigningMarshaler{Marshaler: &jetstream.NATSMarshaler{}, Signer: testHMACSigner, Verifiers: jetstream.Verifiers{"hmac-1": testHMACSigner}}},
+	{"registry-nats", jetstream.NewDefaultMarshalerRegistry(jetstream.NATSContentType)},
+}
+
+var testHMACSigner = jetstream.HMACSigner{ID: "hmac-1", Secret: []byte("secret")}
+
+var testKeyRing = mustNewKeyRing("key-1", map[string][]byte{"key-1": bytes.Repeat([]byte{1}, 32)})
+
+func mustNewKeyRing(currentID string, keys map[string][]byte) *jetstream.KeyRing {
+	keyRing, err := jetstream.NewKeyRing(currentID, keys)
+	if err != nil {
+		panic(err)
+	}
+	return keyRing
 }
 
 func TestMarshalers(t *testing.T) {
@@ -124,6 +148,54 @@ func TestNatsMarshaler_Error_Multiple_Values_In_Single_Header(t *testing.T) {
 	_, err := marshaler.Unmarshal(b)
 
 	require.Error(t, err)
+
+	var multipleValuesErr *jetstream.MultipleHeaderValuesError
+	require.ErrorAs(t, err, &multipleValuesErr)
+	assert.False(t, multipleValuesErr.Permanent(), "messages should be redelivered, not terminated")
+}
+
+func TestNatsMarshaler_Multi_Value_Headers(t *testing.T) {
+	testCases := []struct {
+		name          string
+		marshaler     *jetstream.NATSMarshaler
+		expectedValue string
+		roundTrips    bool
+	}{
+		{"join", &jetstream.NATSMarshaler{MultiValueHeaders: jetstream.MultiValueHeaderJoin}, "bar,baz", true},
+		{"join custom separator", &jetstream.NATSMarshaler{MultiValueHeaders: jetstream.MultiValueHeaderJoin, MultiValueSeparator: "|"}, "bar|baz", true},
+		{"first", &jetstream.NATSMarshaler{MultiValueHeaders: jetstream.MultiValueHeaderFirst}, "bar", false},
+		{"last", &jetstream.NATSMarshaler{MultiValueHeaders: jetstream.MultiValueHeaderLast}, "baz", false},
+		{"json", &jetstream.NATSMarshaler{MultiValueHeaders: jetstream.MultiValueHeaderJSON}, `["bar","baz"]`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			natsMsg := nats.NewMsg("fizz")
+			natsMsg.Header.Set(jetstream.WatermillUUIDHdr, watermill.NewUUID())
+			natsMsg.Header.Add("foo", "bar")
+			natsMsg.Header.Add("foo", "baz")
+			natsMsg.Header.Set("single", "value")
+			natsMsg.Header.Set("separated", `bar,baz|["bar","baz"]`)
+
+			msg, err := tc.marshaler.Unmarshal(natsMsg)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedValue, msg.Metadata.Get("foo"))
+			assert.Equal(t, "value", msg.Metadata.Get("single"))
+
+			marshaled, err := tc.marshaler.Marshal("fizz", msg)
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"value"}, marshaled.Header.Values("single"))
+			assert.Equal(t, []string{`bar,baz|["bar","baz"]`}, marshaled.Header.Values("separated"), "values which were not joined should not be split")
+			assert.Empty(t, marshaled.Header.Values(jetstream.MultiValueHeadersMetadataKey))
+			if tc.roundTrips {
+				assert.Equal(t, []string{"bar", "baz"}, marshaled.Header.Values("foo"))
+			} else {
+				assert.Equal(t, []string{tc.expectedValue}, marshaled.Header.Values("foo"))
+			}
+		})
+	}
 }
 
 func TestNatsMarshaler_Skips_Reserved_Headers(t *testing.T) {
@@ -149,6 +221,166 @@ func TestNatsMarshaler_Skips_Reserved_Headers(t *testing.T) {
 	}
 }
 
+func TestProtoMarshaler_Header_Transport(t *testing.T) {
+	marshaler := &wmpb.NATSMarshaler{HeaderTransport: true}
+
+	msg := sampleMessage(100)
+	msg.Metadata.Set("foo", "bar")
+
+	natsMsg, err := marshaler.Marshal("topic", msg)
+	require.NoError(t, err)
+
+	assert.Equal(t, msg.UUID, natsMsg.Header.Get(jetstream.WatermillUUIDHdr))
+	assert.Equal(t, "bar", natsMsg.Header.Get("foo"))
+
+	pbMsg := &wmpb.Message{}
+	require.NoError(t, proto.Unmarshal(natsMsg.Data, pbMsg))
+
+	assert.Equal(t, wmpb.HeaderTransportVersion, pbMsg.GetVersion())
+	assert.Empty(t, pbMsg.GetUuid())
+	assert.Empty(t, pbMsg.GetMetadata())
+	assert.Equal(t, []byte(msg.Payload), pbMsg.GetPayload())
+}
+
+func TestProtoMarshaler_Reads_Both_Versions(t *testing.T) {
+	envelopeMarshaler := &wmpb.NATSMarshaler{}
+	headerMarshaler := &wmpb.NATSMarshaler{HeaderTransport: true}
+
+	msg := sampleMessage(100)
+
+	envelopeMsg, err := envelopeMarshaler.Marshal("topic", msg)
+	require.NoError(t, err)
+
+	headerMsg, err := headerMarshaler.Marshal("topic", msg)
+	require.NoError(t, err)
+
+	for _, unmarshaler := range []*wmpb.NATSMarshaler{envelopeMarshaler, headerMarshaler} {
+		for _, natsMsg := range []*nats.Msg{envelopeMsg, headerMsg} {
+			unmarshaledMsg, err := unmarshaler.Unmarshal(natsMsg)
+			require.NoError(t, err)
+
+			assert.True(t, msg.Equals(unmarshaledMsg))
+		}
+	}
+}
+
+func TestProtoMarshaler_Typed_Fields(t *testing.T) {
+	marshaler := &wmpb.NATSMarshaler{}
+
+	msg := sampleMessage(100)
+	msg.Metadata.Set(wmpb.ProducedAtMetadataKey, "2022-10-01T12:30:00.123456789Z")
+	msg.Metadata.Set(wmpb.TypeMetadataKey, "orders.OrderPlaced")
+	msg.Metadata.Set(wmpb.ContentTypeMetadataKey, "application/json")
+	msg.Metadata.Set(wmpb.CorrelationIDMetadataKey, "correlation-1")
+	msg.Metadata.Set(wmpb.CausationIDMetadataKey, "causation-1")
+	msg.Metadata.Set(wmpb.ProducerMetadataKey, "orders-service")
+
+	natsMsg, err := marshaler.Marshal("topic", msg)
+	require.NoError(t, err)
+
+	pbMsg := &wmpb.Message{}
+	require.NoError(t, proto.Unmarshal(natsMsg.Data, pbMsg))
+
+	assert.Equal(t, time.Date(2022, 10, 1, 12, 30, 0, 123456789, time.UTC), pbMsg.GetProducedAt().AsTime())
+	assert.Equal(t, "orders.OrderPlaced", pbMsg.GetType())
+	assert.Equal(t, "application/json", pbMsg.GetContentType())
+	assert.Equal(t, "correlation-1", pbMsg.GetCorrelationId())
+	assert.Equal(t, "causation-1", pbMsg.GetCausationId())
+	assert.Equal(t, "orders-service", pbMsg.GetProducer())
+	// typed fields are also kept in metadata, for consumers using the previous message.proto
+	assert.Len(t, pbMsg.GetMetadata(), 16)
+	for k, v := range msg.Metadata {
+		assert.Equal(t, v, pbMsg.GetMetadata()[k])
+	}
+
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+
+	assert.True(t, msg.Equals(unmarshaledMsg))
+
+	// the metadata map wins over typed fields
+	pbMsg.Metadata[wmpb.CorrelationIDMetadataKey] = "correlation-2"
+	delete(pbMsg.Metadata, wmpb.CausationIDMetadataKey)
+	natsMsg.Data, err = proto.Marshal(pbMsg)
+	require.NoError(t, err)
+
+	unmarshaledMsg, err = marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+
+	assert.Equal(t, "correlation-2", unmarshaledMsg.Metadata.Get(wmpb.CorrelationIDMetadataKey))
+	assert.Equal(t, "causation-1", unmarshaledMsg.Metadata.Get(wmpb.CausationIDMetadataKey))
+}
+
+func TestProtoMarshaler_Typed_Fields_Not_Lossless(t *testing.T) {
+	marshaler := &wmpb.NATSMarshaler{}
+
+	msg := sampleMessage(100)
+	msg.Metadata.Set(wmpb.ProducedAtMetadataKey, "2022-10-01T14:30:00+02:00")
+
+	natsMsg, err := marshaler.Marshal("topic", msg)
+	require.NoError(t, err)
+
+	pbMsg := &wmpb.Message{}
+	require.NoError(t, proto.Unmarshal(natsMsg.Data, pbMsg))
+
+	assert.Nil(t, pbMsg.GetProducedAt())
+	assert.Equal(t, "2022-10-01T14:30:00+02:00", pbMsg.GetMetadata()[wmpb.ProducedAtMetadataKey])
+
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+
+	assert.True(t, msg.Equals(unmarshaledMsg))
+}
+
+func TestProtoMarshaler_Producer_Defaults(t *testing.T) {
+	for _, headerTransport := range []bool{false, true} {
+		t.Run(fmt.Sprintf("header_transport_%t", headerTransport), func(t *testing.T) {
+			marshaler := &wmpb.NATSMarshaler{
+				HeaderTransport: headerTransport,
+				Producer:        "orders-service",
+				StampProducedAt: true,
+			}
+
+			msg := sampleMessage(100)
+
+			natsMsg, err := marshaler.Marshal("topic", msg)
+			require.NoError(t, err)
+
+			assert.Empty(t, msg.Metadata.Get(wmpb.ProducerMetadataKey), "message should not be modified")
+
+			unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+			require.NoError(t, err)
+
+			assert.Equal(t, "orders-service", unmarshaledMsg.Metadata.Get(wmpb.ProducerMetadataKey))
+
+			producedAt, err := time.Parse(time.RFC3339Nano, unmarshaledMsg.Metadata.Get(wmpb.ProducedAtMetadataKey))
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now(), producedAt, time.Minute)
+
+			msg.Metadata.Set(wmpb.ProducerMetadataKey, "other-service")
+
+			natsMsg, err = marshaler.Marshal("topic", msg)
+			require.NoError(t, err)
+
+			unmarshaledMsg, err = marshaler.Unmarshal(natsMsg)
+			require.NoError(t, err)
+
+			assert.Equal(t, "other-service", unmarshaledMsg.Metadata.Get(wmpb.ProducerMetadataKey))
+		})
+	}
+}
+
+func TestProtoMarshaler_Unsupported_Version(t *testing.T) {
+	data, err := proto.Marshal(&wmpb.Message{Version: 42})
+	require.NoError(t, err)
+
+	natsMsg := nats.NewMsg("topic")
+	natsMsg.Data = data
+
+	_, err = (&wmpb.NATSMarshaler{}).Unmarshal(natsMsg)
+	require.Error(t, err)
+}
+
 func assertReservedKey(t *testing.T, natsMsg *nats.Msg, hdr string, unmarshaler *jetstream.NATSMarshaler) {
 	natsMsg.Header.Add(hdr, uuid.NewString())
 	defer natsMsg.Header.Del(hdr)
@@ -1,20 +1,81 @@
 package wmpb
 
 import (
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
 	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ContentType is the content type of messages written by NATSMarshaler, used with jetstream.MarshalerRegistry.
+const ContentType = "application/x-protobuf; messageType=wmpb.Message"
+
+// Versions of the Message format.
+const (
+	// EnvelopeVersion carries the UUID, metadata and payload in the protobuf body.
+	EnvelopeVersion uint32 = 0
+
+	// HeaderTransportVersion carries only the payload in the protobuf body, the UUID and metadata
+	// are sent as NATS headers (the same way as jetstream.NATSMarshaler does).
+	HeaderTransportVersion uint32 = 1
+)
+
+// Metadata keys mapped to the typed fields of Message.
+const (
+	// ProducedAtMetadataKey holds the producer timestamp, formatted as time.RFC3339Nano in UTC.
+	ProducedAtMetadataKey  = "produced_at"
+	TypeMetadataKey        = "type"
+	ContentTypeMetadataKey = "content_type"
+	// CorrelationIDMetadataKey is the same key as used by the watermill CorrelationID middleware.
+	CorrelationIDMetadataKey = middleware.CorrelationIDMetadataKey
+	CausationIDMetadataKey   = "causation_id"
+	ProducerMetadataKey      = "producer"
 )
 
-type NATSMarshaler struct{}
+// NATSMarshaler marshals watermill messages to protobuf Message.
+//
+// In the envelope format, metadata with the keys listed above is also stored in the typed fields of Message,
+// so consumers in other languages can read them with message.proto alone. All metadata is kept in the
+// metadata map, so consumers using the previous message.proto still see it. Typed fields are mapped back
+// to metadata by Unmarshal only for keys missing from the metadata map (for example set by other producers).
+// Values which can't be stored losslessly (for example produced_at not in the format of ProducedAtMetadataKey)
+// are not stored in typed fields.
+//
+// Unmarshal reads both formats, regardless of HeaderTransport.
+type NATSMarshaler struct {
+	// HeaderTransport enables the hybrid mode: the UUID and metadata are sent as NATS headers,
+	// so they are visible to the nats CLI and subject filters, and JetStream deduplication can use them.
+	// Consumers must be upgraded to a version supporting HeaderTransportVersion before it is enabled.
+	HeaderTransport bool
+
+	// Headers maps the UUID and metadata to NATS headers in header transport mode.
+	Headers jetstream.NATSMarshaler
+
+	// Producer is the producer identity used for messages without ProducerMetadataKey metadata.
+	Producer string
+
+	// StampProducedAt sets the producer timestamp to the current time for messages without
+	// ProducedAtMetadataKey metadata.
+	StampProducedAt bool
+}
+
+func (m *NATSMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, error) {
+	if m.HeaderTransport {
+		return m.marshalHeaderTransport(topic, msg)
+	}
 
-func (*NATSMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, error) {
 	pbMsg := &Message{
-		Uuid:     msg.UUID,
-		Metadata: msg.Metadata,
-		Payload:  msg.Payload,
+		Uuid:    msg.UUID,
+		Payload: msg.Payload,
 	}
 
+	setTypedFields(pbMsg, m.withDefaults(msg.Metadata))
+
 	data, err := proto.Marshal(pbMsg)
 
 	if err != nil {
@@ -27,7 +88,132 @@ func (*NATSMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, er
 	return natsMsg, nil
 }
 
-func (*NATSMarshaler) Unmarshal(msg *nats.Msg) (*message.Message, error) {
+// withDefaults returns metadata with the producer identity and timestamp set, when configured and missing.
+func (m *NATSMarshaler) withDefaults(metadata message.Metadata) message.Metadata {
+	setProducer := m.Producer != "" && metadata.Get(ProducerMetadataKey) == ""
+	setProducedAt := m.StampProducedAt && metadata.Get(ProducedAtMetadataKey) == ""
+
+	if !setProducer && !setProducedAt {
+		return metadata
+	}
+
+	withDefaults := make(message.Metadata, len(metadata)+2)
+	for k, v := range metadata {
+		withDefaults[k] = v
+	}
+
+	if setProducer {
+		withDefaults.Set(ProducerMetadataKey, m.Producer)
+	}
+	if setProducedAt {
+		withDefaults.Set(ProducedAtMetadataKey, time.Now().UTC().Format(time.RFC3339Nano))
+	}
+
+	return withDefaults
+}
+
+// setTypedFields sets metadata to pbMsg, copying keys with a typed field to the field.
+func setTypedFields(pbMsg *Message, metadata message.Metadata) {
+	stringFields := map[string]*string{
+		TypeMetadataKey:          &pbMsg.Type,
+		ContentTypeMetadataKey:   &pbMsg.ContentType,
+		CorrelationIDMetadataKey: &pbMsg.CorrelationId,
+		CausationIDMetadataKey:   &pbMsg.CausationId,
+		ProducerMetadataKey:      &pbMsg.Producer,
+	}
+
+	for k, v := range metadata {
+		if field, ok := stringFields[k]; ok && v != "" {
+			*field = v
+		}
+
+		if k == ProducedAtMetadataKey {
+			if producedAt, ok := parseProducedAt(v); ok {
+				pbMsg.ProducedAt = producedAt
+			}
+		}
+
+		if pbMsg.Metadata == nil {
+			pbMsg.Metadata = make(map[string]string, len(metadata))
+		}
+		pbMsg.Metadata[k] = v
+	}
+}
+
+// typedFieldsMetadata returns metadata of pbMsg, including typed fields missing from the metadata map.
+func typedFieldsMetadata(pbMsg *Message) message.Metadata {
+	metadata := message.Metadata(pbMsg.GetMetadata())
+
+	typed := map[string]string{
+		TypeMetadataKey:          pbMsg.GetType(),
+		ContentTypeMetadataKey:   pbMsg.GetContentType(),
+		CorrelationIDMetadataKey: pbMsg.GetCorrelationId(),
+		CausationIDMetadataKey:   pbMsg.GetCausationId(),
+		ProducerMetadataKey:      pbMsg.GetProducer(),
+	}
+	if pbMsg.GetProducedAt() != nil {
+		typed[ProducedAtMetadataKey] = formatProducedAt(pbMsg.GetProducedAt())
+	}
+
+	for k, v := range typed {
+		if v == "" {
+			continue
+		}
+		if _, ok := metadata[k]; ok {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(message.Metadata)
+		}
+		metadata.Set(k, v)
+	}
+
+	return metadata
+}
+
+// parseProducedAt parses the producer timestamp, only if formatting it back gives the same value.
+func parseProducedAt(value string) (*timestamppb.Timestamp, bool) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, false
+	}
+
+	producedAt := timestamppb.New(t)
+	if formatProducedAt(producedAt) != value {
+		return nil, false
+	}
+
+	return producedAt, true
+}
+
+func formatProducedAt(producedAt *timestamppb.Timestamp) string {
+	return producedAt.AsTime().UTC().Format(time.RFC3339Nano)
+}
+
+func (m *NATSMarshaler) marshalHeaderTransport(topic string, msg *message.Message) (*nats.Msg, error) {
+	// the payload is set to the protobuf body below
+	headersMsg := message.NewMessage(msg.UUID, nil)
+	headersMsg.Metadata = m.withDefaults(msg.Metadata)
+
+	natsMsg, err := m.Headers.Marshal(topic, headersMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := proto.Marshal(&Message{
+		Payload: msg.Payload,
+		Version: HeaderTransportVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	natsMsg.Data = data
+
+	return natsMsg, nil
+}
+
+func (m *NATSMarshaler) Unmarshal(msg *nats.Msg) (*message.Message, error) {
 	pbMsg := &Message{}
 
 	err := proto.Unmarshal(msg.Data, pbMsg)
@@ -36,8 +222,19 @@ func (*NATSMarshaler) Unmarshal(msg *nats.Msg) (*message.Message, error) {
 		return nil, err
 	}
 
-	wmMsg := message.NewMessage(pbMsg.GetUuid(), pbMsg.GetPayload())
-	wmMsg.Metadata = pbMsg.GetMetadata()
+	switch pbMsg.GetVersion() {
+	case EnvelopeVersion:
+		wmMsg := message.NewMessage(pbMsg.GetUuid(), pbMsg.GetPayload())
+		wmMsg.Metadata = typedFieldsMetadata(pbMsg)
 
-	return wmMsg, nil
+		return wmMsg, nil
+	case HeaderTransportVersion:
+		return m.Headers.Unmarshal(&nats.Msg{
+			Subject: msg.Subject,
+			Header:  msg.Header,
+			Data:    pbMsg.GetPayload(),
+		})
+	default:
+		return nil, errors.Errorf("unsupported wmpb.Message version %d", pbMsg.GetVersion())
+	}
 }